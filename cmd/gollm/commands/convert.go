@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"fmt"
+	"gollm/internal/model"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert model weights between formats",
+	Long: `Convert model weights between the gollm checkpoint format and safetensors.
+The format of each file is chosen by its extension: .safetensors for
+safetensors, anything else for a gollm checkpoint. The input may also be a
+HuggingFace GPT-2 directory holding config.json and model.safetensors.
+Example: gollm convert --input models/gpt2.pt --output models/gpt2.safetensors`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inputPath, _ := cmd.Flags().GetString("input")
+		outputPath, _ := cmd.Flags().GetString("output")
+		if err := convertModel(inputPath, outputPath); err != nil {
+			log.Fatalf("Conversion failed: %v", err)
+		}
+		fmt.Printf("Converted %s to %s\n", inputPath, outputPath)
+	},
+}
+
+func init() {
+	convertCmd.Flags().StringP("input", "i", "", "Model file to read")
+	convertCmd.Flags().StringP("output", "o", "", "Model file to write")
+	convertCmd.MarkFlagRequired("input")
+	convertCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(convertCmd)
+}
+
+func convertModel(inputPath, outputPath string) error {
+	gpt, err := loadAnyModel(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to load model: %v", err)
+	}
+	defer gpt.Close()
+
+	if isSafetensors(outputPath) {
+		return gpt.SaveSafetensors(outputPath)
+	}
+	return gpt.Save(outputPath)
+}
+
+// loadAnyModel loads a gollm checkpoint, a safetensors file or a HuggingFace
+// GPT-2 directory, building the model from the configuration it records
+func loadAnyModel(path string) (*model.GPT2, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return model.ImportHFGPT2(path)
+	}
+
+	cfg, err := model.ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The weights are replaced by the loaded ones, so the seed does not matter
+	gpt := model.NewGPT2(cfg, rand.New(rand.NewSource(1)))
+
+	if isSafetensors(path) {
+		err = gpt.LoadSafetensors(path)
+	} else {
+		err = gpt.Load(path)
+	}
+	return gpt, err
+}
+
+// isSafetensors reports whether path names a safetensors file
+func isSafetensors(path string) bool {
+	return filepath.Ext(path) == ".safetensors"
+}
@@ -14,31 +14,37 @@ var encodeCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		vocabPath, _ := cmd.Flags().GetString("vocab")
 		text, _ := cmd.Flags().GetString("text")
-		encodeText(vocabPath, text)
+		parseSpecial, _ := cmd.Flags().GetBool("parse-special")
+		encodeText(vocabPath, text, parseSpecial)
 	},
 }
 
 func init() {
 	encodeCmd.Flags().StringP("vocab", "v", "", "Path to the vocabulary file")
 	encodeCmd.Flags().StringP("text", "t", "", "Text to encode")
+	encodeCmd.Flags().Bool("parse-special", false, "Encode special tokens written in the text as themselves")
 	encodeCmd.MarkFlagRequired("vocab")
 	encodeCmd.MarkFlagRequired("text")
 }
 
-func encodeText(vocabPath string, text string) {
-	bpe := tokenizer.NewBPE(0)
-	if err := bpe.LoadVocab(vocabPath); err != nil {
+func encodeText(vocabPath string, text string, parseSpecial bool) {
+	tok, err := tokenizer.Load(vocabPath)
+	if err != nil {
 		log.Fatalf("Error loading vocabulary: %v\nDid you train the tokenizer first?", err)
 	}
 
-	ids := bpe.Encode(text)
+	var ids []int
+	if parseSpecial {
+		ids = tokenizer.EncodeSpecial(tok, text)
+	} else {
+		ids = tok.Encode(text)
+	}
 
 	fmt.Println("Encoded tokens:")
 	fmt.Println(ids)
 
 	fmt.Println("\nToken breakdown:")
 	for _, id := range ids {
-		token := bpe.Vocab().GetWord(id)
-		fmt.Printf("%q -> %d\n", token, id)
+		fmt.Printf("%q -> %d\n", tok.Token(id), id)
 	}
 }
@@ -2,7 +2,6 @@ package commands
 
 import (
 	"fmt"
-	"gollm/configs"
 	"gollm/internal/model"
 	"gollm/internal/tokenizer"
 	"log"
@@ -14,7 +13,9 @@ var generateCmd = &cobra.Command{
 	Use:   "generate",
 	Short: "Generate text from a prompt",
 	Long: `Generate text from a given prompt using the trained model.
-Example: gollm generate "Once upon a time" --temperature 0.7`,
+Example: gollm generate "Once upon a time" --temperature 0.7 --top-p 0.9
+
+A temperature of 0 always picks the most likely token (greedy decoding).`,
 	Run: runGenerate,
 }
 
@@ -24,8 +25,22 @@ func init() {
 	generateCmd.Flags().StringP("model", "m", "", "path to model file")
 	generateCmd.Flags().StringP("vocab", "v", "", "path to vocabulary file")
 	generateCmd.Flags().StringP("prompt", "p", "", "text prompt to start generation")
-	generateCmd.Flags().Float32P("temperature", "t", 0.7, "sampling temperature")
+	generateCmd.Flags().Float32P("temperature", "t", 0.7, "sampling temperature, 0 for greedy decoding")
+	generateCmd.Flags().Int("top-k", 0, "sample only from the k most likely tokens (0 disables)")
+	generateCmd.Flags().Float32("top-p", 0, "nucleus sampling probability mass (0 disables)")
+	generateCmd.Flags().Float32("min-p", 0, "drop tokens less likely than min-p times the top token (0 disables)")
+	generateCmd.Flags().Float32("typical-p", 0, "locally typical sampling probability mass (0 disables)")
+	generateCmd.Flags().Float32("repetition-penalty", 1, "CTRL-style penalty for tokens already in the window (1 disables)")
+	generateCmd.Flags().Float32("presence-penalty", 0, "subtracted from the logit of every token already in the window")
+	generateCmd.Flags().Float32("frequency-penalty", 0, "subtracted from a token's logit once per occurrence in the window")
+	generateCmd.Flags().Int("penalty-window", 64, "number of recent tokens the penalties look at (0 for all)")
+	generateCmd.Flags().Int("no-repeat-ngram", 0, "never repeat an n-gram of this size (0 disables)")
+	generateCmd.Flags().Bool("penalize-prompt", false, "let the penalties and no-repeat-ngram look at the prompt too")
 	generateCmd.Flags().IntP("max-tokens", "n", 100, "maximum number of tokens to generate")
+	generateCmd.Flags().Int64("seed", 0, "seed for sampling, the same seed gives the same text (0 picks one at random)")
+	generateCmd.Flags().Bool("parse-special", false, "encode special tokens written in the prompt, such as <|endoftext|>, as themselves")
+	generateCmd.Flags().Bool("keep-special", false, "show special tokens in the generated text")
+	generateCmd.Flags().Bool("ignore-eos", false, "keep generating after the end-of-text token")
 	
 	generateCmd.MarkFlagRequired("model")
 	generateCmd.MarkFlagRequired("vocab")
@@ -65,40 +80,61 @@ func runGenerate(cmd *cobra.Command, args []string) {
 	modelPath, _ := cmd.Flags().GetString("model")
 	vocabPath, _ := cmd.Flags().GetString("vocab")
 	prompt, _ := cmd.Flags().GetString("prompt")
-	temperature, _ := cmd.Flags().GetFloat32("temperature")
 	maxTokens, _ := cmd.Flags().GetInt("max-tokens")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	parseSpecial, _ := cmd.Flags().GetBool("parse-special")
+	keepSpecial, _ := cmd.Flags().GetBool("keep-special")
+	ignoreEOS, _ := cmd.Flags().GetBool("ignore-eos")
+
+	params := model.SamplingParams{}
+	params.Temperature, _ = cmd.Flags().GetFloat32("temperature")
+	params.TopK, _ = cmd.Flags().GetInt("top-k")
+	params.TopP, _ = cmd.Flags().GetFloat32("top-p")
+	params.MinP, _ = cmd.Flags().GetFloat32("min-p")
+	params.TypicalP, _ = cmd.Flags().GetFloat32("typical-p")
+	params.RepetitionPenalty, _ = cmd.Flags().GetFloat32("repetition-penalty")
+	params.PresencePenalty, _ = cmd.Flags().GetFloat32("presence-penalty")
+	params.FrequencyPenalty, _ = cmd.Flags().GetFloat32("frequency-penalty")
+	params.PenaltyWindow, _ = cmd.Flags().GetInt("penalty-window")
+	params.NoRepeatNGram, _ = cmd.Flags().GetInt("no-repeat-ngram")
+	params.PenalizePrompt, _ = cmd.Flags().GetBool("penalize-prompt")
 	
 	// Load tokenizer
-	tok := tokenizer.New()
-	if err := tok.Load(vocabPath); err != nil {
+	tok, err := tokenizer.Load(vocabPath)
+	if err != nil {
 		log.Fatalf("Failed to load vocabulary: %v", err)
 	}
 	
-	// Get default config and update with tokenizer vocab size
-	cfg := configs.DefaultConfig()
-	cfg.VocabSize = tok.VocabSize()
-	
-	// Initialize model with config
-	m := model.NewGPT2(model.Config{
-		VocabSize:   cfg.VocabSize,
-		ContextSize: cfg.ContextSize,
-		EmbedDim:    cfg.EmbedDim,
-		NumHeads:    cfg.NumHeads,
-		NumLayers:   cfg.NumLayers,
-	})
-	
-	// Load model weights
-	if err := m.Load(modelPath); err != nil {
+	// Load the model, built from the configuration stored with its weights
+	m, err := loadAnyModel(modelPath)
+	if err != nil {
 		log.Fatalf("Failed to load model: %v", err)
 	}
+	defer m.Close()
+	if m.Config().VocabSize < tok.VocabSize() {
+		log.Fatalf("Vocabulary has %d tokens but the model only %d", tok.VocabSize(), m.Config().VocabSize)
+	}
 	
-	// Encode prompt
-	tokens := tok.Encode(prompt)
+	if eos, ok := tokenizer.SpecialID(tok, tok.Specials().EOS); ok && !ignoreEOS {
+		params.StopTokens = []int{eos}
+	}
 	
-	// Generate text
-	generated := m.Generate(tokens, maxTokens, temperature)
+	// Encode prompt
+	var tokens []int
+	if parseSpecial {
+		tokens = tokenizer.EncodeSpecial(tok, prompt)
+	} else {
+		tokens = tok.Encode(prompt)
+	}
 	
-	// Decode and print
-	text := tok.Decode(generated)
-	fmt.Println(text)
+	// Generate text, printing the prompt and then every token as it arrives
+	rng, _ := newRand(seed)
+	decoder := tokenizer.NewStreamDecoder(tok, tokenizer.DecodeOptions{SkipSpecial: !keepSpecial})
+	for _, id := range tokens {
+		fmt.Print(decoder.Add(id))
+	}
+	for id := range m.GenerateStream(tokens, maxTokens, params, rng) {
+		fmt.Print(decoder.Add(id))
+	}
+	fmt.Println(decoder.Flush())
 }
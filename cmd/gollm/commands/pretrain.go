@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"gollm/configs"
+	"gollm/internal/corpus"
 	"gollm/internal/model"
+	"gollm/internal/optim"
 	"gollm/internal/tokenizer"
 	"log"
 	"os"
@@ -17,20 +19,24 @@ var pretrainCmd = &cobra.Command{
 	Use:   "pretrain",
 	Short: "Pretrain the model on a text corpus",
 	Run: func(cmd *cobra.Command, args []string) {
-		corpusPath, _ := cmd.Flags().GetString("corpus")
+		corpusPaths, _ := cmd.Flags().GetStringSlice("corpus")
 		configPath, _ := cmd.Flags().GetString("config")
-		runPretrain(corpusPath, configPath)
+		resumePath, _ := cmd.Flags().GetString("resume")
+		seed, _ := cmd.Flags().GetInt64("seed")
+		runPretrain(corpusPaths, configPath, resumePath, seed)
 	},
 }
 
 func init() {
-	pretrainCmd.Flags().StringP("corpus", "i", "", "Path to the training corpus")
+	pretrainCmd.Flags().StringSliceP("corpus", "i", nil, "Training corpus: a token file written by tokenize, or text files, directories or globs")
 	pretrainCmd.Flags().StringP("config", "c", "", "Path to model config file (optional)")
+	pretrainCmd.Flags().StringP("resume", "r", "", "Checkpoint to resume training from (optional)")
+	pretrainCmd.Flags().Int64("seed", 0, "Seed for weight initialization (0 picks one at random)")
 	pretrainCmd.MarkFlagRequired("corpus")
 	rootCmd.AddCommand(pretrainCmd)
 }
 
-func runPretrain(corpusPath, configPath string) {
+func runPretrain(corpusPaths []string, configPath, resumePath string, seed int64) {
 	cfg := configs.DefaultConfig()
 	if configPath != "" {
 		configData, err := os.ReadFile(configPath)
@@ -45,32 +51,71 @@ func runPretrain(corpusPath, configPath string) {
 	os.MkdirAll(filepath.Dir(cfg.ModelPath), 0755)
 	os.MkdirAll(cfg.CheckpointDir, 0755)
 
-	tok := tokenizer.New()
-	if err := tok.Load(cfg.VocabPath); err != nil {
+	tok, err := tokenizer.Load(cfg.VocabPath)
+	if err != nil {
 		log.Fatalf("Failed to load vocabulary: %v", err)
 	}
 
 	cfg.VocabSize = tok.VocabSize()
 
-	gpt := model.NewGPT2(model.Config{
-		VocabSize:   cfg.VocabSize,
-		ContextSize: cfg.ContextSize,
-		EmbedDim:    cfg.EmbedDim,
-		NumHeads:    cfg.NumHeads,
-		NumLayers:   cfg.NumLayers,
-	})
+	modelConfig := model.Config{
+		VocabSize:        cfg.VocabSize,
+		ContextSize:      cfg.ContextSize,
+		EmbedDim:         cfg.EmbedDim,
+		NumHeads:         cfg.NumHeads,
+		NumLayers:        cfg.NumLayers,
+		NumKVHeads:       cfg.NumKVHeads,
+		Activation:       cfg.Activation,
+		FFNHiddenDim:     cfg.FFNHiddenDim,
+		NormPosition:     cfg.NormPosition,
+		NormType:         cfg.NormType,
+		PositionEncoding: cfg.PositionEncoding,
+	}
+	if err := modelConfig.Validate(); err != nil {
+		log.Fatalf("Invalid model configuration: %v", err)
+	}
+
+	rng, seed := newRand(seed)
+	fmt.Printf("Using seed %d\n", seed)
+
+	gpt := model.NewGPT2(modelConfig, rng)
+	defer gpt.Close()
 
-	data, err := os.ReadFile(corpusPath)
+	if resumePath != "" {
+		if err := gpt.Load(resumePath); err != nil {
+			log.Fatalf("Failed to load checkpoint: %v", err)
+		}
+	}
+
+	opt, err := newOptimizer(cfg, gpt.Parameters())
 	if err != nil {
-		log.Fatalf("Error reading corpus: %v", err)
+		log.Fatalf("Error creating optimizer: %v", err)
+	}
+	if resumePath != "" {
+		if err := opt.Load(optimizerStatePath(resumePath)); err != nil {
+			log.Fatalf("Failed to load optimizer state: %v", err)
+		}
+		fmt.Printf("Resuming from %s at step %d\n", resumePath, opt.StepCount())
 	}
-	corpus := string(data)
 
-	tokens := tok.Encode(corpus)
+	tokens, err := openTrainingTokens(tok, corpusPaths, cfg.CheckpointDir)
+	if err != nil {
+		log.Fatalf("Error reading corpus: %v", err)
+	}
+	defer tokens.Close()
+	sequence := make([]int, 0, cfg.ContextSize+1)
 
 	fmt.Println("Starting pretraining...")
 	batchSize := cfg.BatchSize
-	numBatches := (len(tokens) - cfg.ContextSize) / batchSize
+	numBatches := (tokens.Len() - cfg.ContextSize) / batchSize
+
+	schedule, err := optim.NewSchedule(cfg.LRSchedule, cfg.LearningRate, cfg.MinLR,
+		cfg.WarmupSteps, numBatches*cfg.MaxEpochs)
+	if err != nil {
+		log.Fatalf("Error creating learning rate schedule: %v", err)
+	}
+	schedule.StepSize = cfg.LRStepSize
+	schedule.Gamma = cfg.LRGamma
 
 	for epoch := 0; epoch < cfg.MaxEpochs; epoch++ {
 		totalLoss := float32(0)
@@ -80,28 +125,39 @@ func runPretrain(corpusPath, configPath string) {
 
 			for i := 0; i < batchSize; i++ {
 				start := batch*batchSize + i
-				if start+cfg.ContextSize >= len(tokens) {
+				if start+cfg.ContextSize >= tokens.Len() {
 					continue
 				}
 
-				sequence := tokens[start : start+cfg.ContextSize]
-				target := tokens[start+1 : start+cfg.ContextSize+1]
+				sequence, err = tokens.Read(start, cfg.ContextSize+1, sequence)
+				if err != nil {
+					log.Fatalf("Error reading corpus: %v", err)
+				}
+				input := sequence[:cfg.ContextSize]
+				target := sequence[1:]
 
-				logits := gpt.Forward(sequence)
+				logits, err := gpt.Forward(input)
+				if err != nil {
+					log.Fatalf("Error in forward pass: %v", err)
+				}
 
-				loss := model.CrossEntropyLoss(logits, target)
+				loss, dLogits := model.CrossEntropyLossGrad(logits, target)
 				batchLoss += loss
 
-				// Backward pass and optimization (to be implemented)
-				// TODO: Implement backpropagation and parameter updates
+				gpt.Backward(dLogits)
 			}
 
+			scaleGradients(gpt.Parameters(), 1/float32(batchSize))
+			opt.SetLearningRate(schedule.LearningRate(opt.StepCount()))
+			opt.Step()
+			gpt.ZeroGrad()
+
 			batchLoss /= float32(batchSize)
 			totalLoss += batchLoss
 
 			if batch%100 == 0 {
-				fmt.Printf("Epoch %d/%d, Batch %d/%d, Loss: %.4f\n",
-					epoch+1, cfg.MaxEpochs, batch+1, numBatches, batchLoss)
+				fmt.Printf("Epoch %d/%d, Batch %d/%d, Loss: %.4f, LR: %.3e\n",
+					epoch+1, cfg.MaxEpochs, batch+1, numBatches, batchLoss, opt.LearningRate())
 			}
 		}
 
@@ -114,10 +170,77 @@ func runPretrain(corpusPath, configPath string) {
 		if err := gpt.Save(checkpointPath); err != nil {
 			log.Printf("Warning: Failed to save checkpoint: %v", err)
 		}
+		if err := opt.Save(optimizerStatePath(checkpointPath)); err != nil {
+			log.Printf("Warning: Failed to save optimizer state: %v", err)
+		}
 	}
 
 	if err := gpt.Save(cfg.ModelPath); err != nil {
 		log.Fatalf("Error saving model: %v", err)
 	}
+	if err := opt.Save(optimizerStatePath(cfg.ModelPath)); err != nil {
+		log.Printf("Warning: Failed to save optimizer state: %v", err)
+	}
 	fmt.Printf("Training complete! Model saved to: %s\n", cfg.ModelPath)
 }
+
+// openTrainingTokens opens the tokenized corpus. A single token file is used
+// as it is; text files are first tokenized into corpus.tok in dir.
+func openTrainingTokens(tok tokenizer.Interface, paths []string, dir string) (*corpus.TokenFile, error) {
+	if len(paths) != 1 || !corpus.IsTokenFile(paths[0]) {
+		files, err := corpus.Expand(paths)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, "corpus.tok")
+		fmt.Printf("Tokenizing %d files into %s...\n", len(files), path)
+		if _, err := encodeCorpus(tok, files, path, false); err != nil {
+			return nil, err
+		}
+		paths = []string{path}
+	}
+
+	tokens, err := corpus.OpenTokenFile(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	if tokens.VocabSize() != tok.VocabSize() {
+		tokens.Close()
+		return nil, fmt.Errorf("%s was tokenized with a vocabulary of %d tokens, not %d",
+			paths[0], tokens.VocabSize(), tok.VocabSize())
+	}
+	fmt.Printf("Training on %d tokens\n", tokens.Len())
+	return tokens, nil
+}
+
+// newOptimizer builds the optimizer selected in the config, excluding biases
+// and normalization parameters from weight decay
+func newOptimizer(cfg *configs.ModelConfig, params []*model.Parameter) (optim.Optimizer, error) {
+	groups := optim.SplitDecay(params, cfg.WeightDecay)
+
+	switch cfg.Optimizer {
+	case "", "adamw":
+		return optim.NewAdamW(groups, cfg.LearningRate, cfg.Beta1, cfg.Beta2), nil
+	case "sgd":
+		return optim.NewSGD(groups, cfg.LearningRate, cfg.Momentum, cfg.Nesterov), nil
+	case "lion":
+		return optim.NewLion(groups, cfg.LearningRate, cfg.Beta1, cfg.Beta2), nil
+	}
+	return nil, fmt.Errorf("unknown optimizer %q", cfg.Optimizer)
+}
+
+// optimizerStatePath returns where the optimizer state of a checkpoint lives
+func optimizerStatePath(checkpointPath string) string {
+	return checkpointPath + ".optim"
+}
+
+// scaleGradients multiplies every accumulated gradient by factor, turning
+// the sum over a batch into a mean
+func scaleGradients(params []*model.Parameter, factor float32) {
+	for _, p := range params {
+		grad := p.Grad.Values()
+		for i := range grad {
+			grad[i] *= factor
+		}
+	}
+}
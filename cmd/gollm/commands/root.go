@@ -2,7 +2,10 @@ package commands
 
 import (
 	"fmt"
+	"gollm/internal/model"
+	"math/rand"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -12,6 +15,10 @@ var rootCmd = &cobra.Command{
 	Short: "GoLLM - A Go implementation of a Large Language Model",
 	Long: `GoLLM is a lightweight implementation of a transformer-based language model in Go.
 It supports training, fine-tuning, and text generation.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		threads, _ := cmd.Flags().GetInt("threads")
+		model.SetNumThreads(threads)
+	},
 }
 
 // Execute executes the root command
@@ -22,9 +29,19 @@ func Execute() {
 	}
 }
 
+// newRand returns a random source for seed, or for a time-based seed if seed
+// is 0, together with the seed actually used so the run can be repeated
+func newRand(seed int64) (*rand.Rand, int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed)), seed
+}
+
 func init() {
 	// Global flags can be added here
 	rootCmd.PersistentFlags().StringP("config", "c", "", "config file (default is ./configs/config.json)")
+	rootCmd.PersistentFlags().Int("threads", 0, "number of threads for matrix multiplication and tokenizer training (default: all CPUs)")
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(trainCmd)
 	rootCmd.AddCommand(encodeCmd)
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"fmt"
+	"gollm/internal/corpus"
+	"gollm/internal/tokenizer"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var tokenizeCmd = &cobra.Command{
+	Use:   "tokenize",
+	Short: "Tokenize a corpus into a binary token file for pretraining",
+	Long: `Tokenize a corpus into a binary token file that pretrain reads directly.
+The corpus may be given as files, directories or globs, plain or gzipped, and
+is read in chunks, so it does not have to fit in memory.
+Example: gollm tokenize --vocab data/vocab/vocab.json --corpus 'data/*.txt.gz' --output data/corpus.tok`,
+	Run: func(cmd *cobra.Command, args []string) {
+		vocabPath, _ := cmd.Flags().GetString("vocab")
+		patterns, _ := cmd.Flags().GetStringSlice("corpus")
+		outputPath, _ := cmd.Flags().GetString("output")
+		parseSpecial, _ := cmd.Flags().GetBool("parse-special")
+
+		tok, err := tokenizer.Load(vocabPath)
+		if err != nil {
+			log.Fatalf("Failed to load vocabulary: %v", err)
+		}
+		files, err := corpus.Expand(patterns)
+		if err != nil {
+			log.Fatalf("Error finding corpus files: %v", err)
+		}
+		count, err := encodeCorpus(tok, files, outputPath, parseSpecial)
+		if err != nil {
+			log.Fatalf("Error tokenizing corpus: %v", err)
+		}
+		fmt.Printf("Wrote %d tokens from %d files to %s\n", count, len(files), outputPath)
+	},
+}
+
+func init() {
+	tokenizeCmd.Flags().StringP("vocab", "v", "", "Path to the vocabulary file")
+	tokenizeCmd.Flags().StringSliceP("corpus", "i", nil, "Corpus files, directories or globs")
+	tokenizeCmd.Flags().StringP("output", "o", "", "Token file to write")
+	tokenizeCmd.Flags().Bool("parse-special", false, "Encode special tokens written in the corpus, such as document separators, as themselves")
+	tokenizeCmd.MarkFlagRequired("vocab")
+	tokenizeCmd.MarkFlagRequired("corpus")
+	tokenizeCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(tokenizeCmd)
+}
+
+// encodeCorpus tokenizes files chunk by chunk into a token file at path and
+// returns the number of tokens written. With parseSpecial, special tokens
+// written in the text are recognized.
+func encodeCorpus(tok tokenizer.Interface, files []string, path string, parseSpecial bool) (int, error) {
+	w, err := corpus.CreateTokenFile(path, tok.VocabSize())
+	if err != nil {
+		return 0, err
+	}
+	err = corpus.ForEach(files, func(text string) error {
+		if parseSpecial {
+			return w.Write(tokenizer.EncodeSpecial(tok, text))
+		}
+		return w.Write(tok.Encode(text))
+	})
+	if err != nil {
+		w.Abort()
+		return 0, err
+	}
+	return w.Count(), w.Close()
+}
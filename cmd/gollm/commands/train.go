@@ -2,9 +2,10 @@ package commands
 
 import (
 	"fmt"
+	"gollm/internal/corpus"
 	"gollm/internal/tokenizer"
 	"log"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -15,35 +16,70 @@ var trainCmd = &cobra.Command{
 	Use:   "train",
 	Short: "Train the tokenizer on a corpus",
 	Run: func(cmd *cobra.Command, args []string) {
-		corpusPath, _ := cmd.Flags().GetString("corpus")
+		corpusPaths, _ := cmd.Flags().GetStringSlice("corpus")
 		vocabSize, _ := cmd.Flags().GetString("vocab-size")
-		trainTokenizer(corpusPath, vocabSize)
+		outputPath, _ := cmd.Flags().GetString("output")
+		threads, _ := cmd.Flags().GetInt("threads")
+
+		specials := tokenizer.Specials{}
+		specials.BOS, _ = cmd.Flags().GetString("bos")
+		specials.EOS, _ = cmd.Flags().GetString("eos")
+		specials.UNK, _ = cmd.Flags().GetString("unk")
+		specials.PAD, _ = cmd.Flags().GetString("pad")
+		specials.Additional, _ = cmd.Flags().GetStringSlice("special-tokens")
+		trainTokenizer(corpusPaths, vocabSize, outputPath, threads, specials)
 	},
 }
 
 func init() {
-	trainCmd.Flags().StringP("corpus", "i", "", "Path to the corpus file")
+	trainCmd.Flags().StringSliceP("corpus", "i", nil, "Corpus files, directories or globs, plain or gzipped")
 	trainCmd.Flags().StringP("vocab-size", "v", "", "Vocabulary size")
+	trainCmd.Flags().StringP("output", "o", "", "Vocabulary file to write (default: <corpus>-vocab-<size>.json)")
+	defaults := tokenizer.DefaultSpecials()
+	trainCmd.Flags().String("bos", defaults.BOS, "Beginning-of-text token (empty for none)")
+	trainCmd.Flags().String("eos", defaults.EOS, "End-of-text token, which stops generation (empty for none)")
+	trainCmd.Flags().String("unk", defaults.UNK, "Token for unknown characters (empty to drop them)")
+	trainCmd.Flags().String("pad", defaults.PAD, "Padding token (empty for none)")
+	trainCmd.Flags().StringSlice("special-tokens", nil, "Additional special tokens to reserve in the vocabulary")
 	trainCmd.MarkFlagRequired("corpus")
 	trainCmd.MarkFlagRequired("vocab-size")
 }
 
-func trainTokenizer(corpusPath string, vocabSize string) {
+func trainTokenizer(corpusPaths []string, vocabSize string, vocabFile string, threads int, specials tokenizer.Specials) {
 	maxVocab, err := strconv.Atoi(vocabSize)
 	if err != nil {
 		log.Fatalf("Invalid vocab size: %v", err)
 	}
 
-	content, err := os.ReadFile(corpusPath)
+	if vocabFile == "" {
+		if len(corpusPaths) != 1 || strings.ContainsAny(corpusPaths[0], "*?[") {
+			log.Fatalf("Pass --output to name the vocabulary of several corpus files")
+		}
+		base := strings.TrimSuffix(filepath.Clean(corpusPaths[0]), ".gz")
+		vocabFile = fmt.Sprintf("%s-vocab-%d.json", strings.TrimSuffix(base, ".txt"), maxVocab)
+	}
+
+	files, err := corpus.Expand(corpusPaths)
+	if err != nil {
+		log.Fatalf("Error finding corpus files: %v", err)
+	}
+
+	fmt.Printf("Counting words in %d files...\n", len(files))
+	counts := tokenizer.NewWordCounts(threads)
+	err = corpus.ForEach(files, func(text string) error {
+		counts.Add(text)
+		return nil
+	})
 	if err != nil {
 		log.Fatalf("Error reading corpus: %v", err)
 	}
 
 	bpe := tokenizer.NewBPE(maxVocab)
-	bpe.Train(string(content))
+	bpe.SetWorkers(threads)
+	bpe.SetSpecials(specials)
+	bpe.TrainCounts(counts)
 
-	vocabFile := fmt.Sprintf("%s-vocab-%d.json", strings.TrimSuffix(corpusPath, ".txt"), maxVocab)
-	if err := bpe.SaveVocab(vocabFile); err != nil {
+	if err := bpe.Save(vocabFile); err != nil {
 		log.Fatalf("Error saving vocabulary: %v", err)
 	}
 
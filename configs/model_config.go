@@ -8,11 +8,56 @@ type ModelConfig struct {
 	NumHeads    int `json:"num_heads"`
 	NumLayers   int `json:"num_layers"`
 
+	// NumKVHeads is the number of key and value heads shared by the query
+	// heads: 1 for multi-query attention, a divisor of NumHeads for
+	// grouped-query attention, 0 or NumHeads for standard attention
+	NumKVHeads int `json:"num_kv_heads"`
+
+	// Feed-forward layers: Activation is "gelu_tanh" (GPT-2's GELU
+	// approximation), "gelu", "relu", "silu", or one of the gated units
+	// "swiglu", "geglu" and "reglu". FFNHiddenDim defaults to 4*EmbedDim
+	Activation   string `json:"activation"`
+	FFNHiddenDim int    `json:"ffn_hidden_dim"`
+
+	// NormPosition places the LayerNorms of each block: "post" normalizes
+	// after each residual add, as in the original transformer, "pre" before
+	// each sublayer, as in GPT-2. Checkpoints record it, so a resumed model
+	// keeps the layout it was trained with
+	NormPosition string `json:"norm_position"`
+	// NormType is "layernorm" or "rmsnorm", which only rescales each
+	// position and has no bias
+	NormType string `json:"norm_type"`
+
+	// PositionEncoding is "learned" (a trained embedding per position, as in
+	// GPT-2), "sinusoidal" (fixed sine and cosine embeddings), "rope"
+	// (rotary embeddings of queries and keys) or "alibi" (linear distance
+	// penalties on attention scores). All but "learned" work past
+	// ContextSize
+	PositionEncoding string `json:"position_encoding"`
+
 	// Training configuration
 	LearningRate float32 `json:"learning_rate"`
 	BatchSize    int     `json:"batch_size"`
 	MaxEpochs    int     `json:"max_epochs"`
 
+	// Learning rate schedule: "constant", "cosine", "linear" or "step", each
+	// preceded by WarmupSteps of linear warmup. Step decay multiplies the
+	// rate by LRGamma every LRStepSize steps
+	LRSchedule  string  `json:"lr_schedule"`
+	WarmupSteps int     `json:"warmup_steps"`
+	MinLR       float32 `json:"min_lr"`
+	LRStepSize  int     `json:"lr_step_size"`
+	LRGamma     float32 `json:"lr_gamma"`
+
+	// Optimizer: "adamw", "sgd" or "lion". Beta1/Beta2 are used by AdamW and
+	// Lion, Momentum/Nesterov by SGD
+	Optimizer   string  `json:"optimizer"`
+	WeightDecay float32 `json:"weight_decay"`
+	Beta1       float32 `json:"beta1"`
+	Beta2       float32 `json:"beta2"`
+	Momentum    float32 `json:"momentum"`
+	Nesterov    bool    `json:"nesterov"`
+
 	// Generation settings
 	DefaultTemperature float32 `json:"default_temperature"`
 	MaxTokens          int     `json:"max_tokens"`
@@ -31,9 +76,21 @@ func DefaultConfig() *ModelConfig {
 		EmbedDim:           384,   // Increased embedding dimension
 		NumHeads:           6,     // Increased attention heads
 		NumLayers:          6,     // Increased layers
+		NormPosition:       "post",
+		NormType:           "layernorm",
+		PositionEncoding:   "learned",
+		Activation:         "gelu_tanh",
 		LearningRate:       1e-4,
 		BatchSize:          32,
 		MaxEpochs:          10,
+		LRSchedule:         "constant",
+		LRStepSize:         1000,
+		LRGamma:            0.1,
+		Optimizer:          "adamw",
+		WeightDecay:        0.01,
+		Beta1:              0.9,
+		Beta2:              0.999,
+		Momentum:           0.9,
 		DefaultTemperature: 0.7,
 		MaxTokens:          100,
 		ModelPath:          "models/gollm.pt",
@@ -0,0 +1,220 @@
+// Package corpus reads training text from many, possibly compressed, files
+// without loading them into memory, and stores tokenized corpora on disk.
+package corpus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultChunkSize is the chunk size ForEach reads text with
+const DefaultChunkSize = 1 << 20
+
+// Expand resolves corpus paths to files. Each pattern may name a file, a
+// directory, whose files are read recursively, or a glob. Files are returned
+// in lexical order within each pattern, and a pattern matching nothing is an
+// error.
+func Expand(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				files = append(files, match)
+				continue
+			}
+			var dirFiles []string
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".") {
+					dirFiles = append(dirFiles, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %v", match, err)
+			}
+			sort.Strings(dirFiles)
+			files = append(files, dirFiles...)
+		}
+	}
+	return files, nil
+}
+
+// Open opens a corpus file, decompressing it if it is gzipped
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read gzip header of %s: %v", path, err)
+		}
+		return &gzipFile{Reader: gz, file: f}, nil
+	}
+	return &bufferedFile{Reader: br, file: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if cerr := g.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type bufferedFile struct {
+	*bufio.Reader
+	file *os.File
+}
+
+func (b *bufferedFile) Close() error {
+	return b.file.Close()
+}
+
+// Reader reads the text of a list of files in chunks of at most the chunk
+// size. Chunks end after a newline where possible and otherwise before
+// whitespace, so a word is only split when it is longer than a chunk. A
+// chunk never spans two files.
+type Reader struct {
+	files     []string
+	chunkSize int
+
+	current io.ReadCloser
+	buf     []byte // read from current but not returned yet
+}
+
+// NewReader returns a Reader over files, cutting chunks of up to chunkSize
+// bytes
+func NewReader(files []string, chunkSize int) *Reader {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Reader{files: files, chunkSize: chunkSize}
+}
+
+// Next returns the next chunk of text, or io.EOF after the last file
+func (r *Reader) Next() (string, error) {
+	for {
+		if r.current == nil {
+			if len(r.files) == 0 {
+				return "", io.EOF
+			}
+			f, err := Open(r.files[0])
+			if err != nil {
+				return "", err
+			}
+			r.current = f
+			r.files = r.files[1:]
+			if r.buf == nil {
+				r.buf = make([]byte, 0, r.chunkSize)
+			}
+		}
+
+		n, err := io.ReadFull(r.current, r.buf[len(r.buf):r.chunkSize])
+		r.buf = r.buf[:len(r.buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if cerr := r.current.Close(); cerr != nil {
+				return "", cerr
+			}
+			r.current = nil
+			if len(r.buf) == 0 {
+				continue
+			}
+			chunk := string(r.buf)
+			r.buf = r.buf[:0]
+			return chunk, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		end := cutPoint(r.buf)
+		chunk := string(r.buf[:end])
+		r.buf = r.buf[:copy(r.buf, r.buf[end:])]
+		return chunk, nil
+	}
+}
+
+// cutPoint returns the length of the chunk to cut from the full buffer b:
+// up to its last newline, else up to its last whitespace, else at the last
+// character boundary
+func cutPoint(b []byte) int {
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		return i + 1
+	}
+	if i := bytes.LastIndexFunc(b, unicode.IsSpace); i > 0 {
+		return i
+	}
+	for i := len(b) - 1; i > 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// Close closes the file being read
+func (r *Reader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+// ForEach calls fn with every chunk of files, stopping at the first error
+func ForEach(files []string, fn func(text string) error) error {
+	r := NewReader(files, DefaultChunkSize)
+	defer r.Close()
+	for {
+		text, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(text); err != nil {
+			return err
+		}
+	}
+}
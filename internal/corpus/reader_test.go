@@ -0,0 +1,135 @@
+package corpus
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func writeFile(t *testing.T, path, text string, compress bool) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", false)
+	writeFile(t, filepath.Join(dir, "b.txt.gz"), "b", true)
+	writeFile(t, filepath.Join(dir, "books", "2.txt"), "2", false)
+	writeFile(t, filepath.Join(dir, "books", "1", "1.txt"), "1", false)
+	writeFile(t, filepath.Join(dir, "books", ".hidden"), "", false)
+
+	got, err := Expand([]string{filepath.Join(dir, "*.txt*"), filepath.Join(dir, "books")})
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "a.txt"),
+		filepath.Join(dir, "b.txt.gz"),
+		filepath.Join(dir, "books", "1", "1.txt"),
+		filepath.Join(dir, "books", "2.txt"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expand() = %v, want %v", got, want)
+	}
+
+	if _, err := Expand([]string{filepath.Join(dir, "missing.txt")}); err == nil {
+		t.Errorf("Expand() of a missing file succeeded")
+	}
+}
+
+func TestReader_ChunksWholeLines(t *testing.T) {
+	dir := t.TempDir()
+	plain := strings.Repeat("the quick brown fox\njumps over\n", 50)
+	compressed := "a line without newline"
+	writeFile(t, filepath.Join(dir, "plain.txt"), plain, false)
+	writeFile(t, filepath.Join(dir, "compressed.gz"), compressed, true)
+
+	r := NewReader([]string{filepath.Join(dir, "plain.txt"), filepath.Join(dir, "compressed.gz")}, 64)
+	defer r.Close()
+	var chunks []string
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if got := strings.Join(chunks, ""); got != plain+compressed {
+		t.Fatalf("chunks do not add up to the files")
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the text cut into several", len(chunks))
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if !strings.HasSuffix(chunk, "\n") {
+			t.Errorf("chunk %d = %q does not end with a whole line", i, chunk)
+		}
+	}
+	if last := chunks[len(chunks)-1]; last != compressed {
+		t.Errorf("last chunk = %q, want the gzipped file on its own", last)
+	}
+}
+
+func TestReader_CutsLongLinesAtWhitespace(t *testing.T) {
+	dir := t.TempDir()
+	text := strings.Repeat("über long line ", 40) + strings.Repeat("ü", 50)
+	writeFile(t, filepath.Join(dir, "long.txt"), text, false)
+
+	r := NewReader([]string{filepath.Join(dir, "long.txt")}, 32)
+	defer r.Close()
+	var chunks []string
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if len(chunk) > 32 {
+			t.Fatalf("chunk of %d bytes exceeds the chunk size", len(chunk))
+		}
+		if !utf8.ValidString(chunk) {
+			t.Fatalf("chunk %q splits a character", chunk)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if got := strings.Join(chunks, ""); got != text {
+		t.Fatalf("chunks do not add up to the file")
+	}
+	// Up to the run of ü, which has no whitespace to cut at
+	end := 0
+	for i, chunk := range chunks[:len(chunks)-1] {
+		end += len(chunk)
+		if end < len(text)-100 && text[end] != ' ' {
+			t.Errorf("chunk %d = %q is not cut before whitespace", i, chunk)
+		}
+	}
+}
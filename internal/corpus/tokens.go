@@ -0,0 +1,216 @@
+package corpus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Token files hold a tokenized corpus: a 16-byte header (magic, version,
+// bytes per token and vocabulary size, little-endian uint32s) followed by
+// the token IDs as little-endian uint16s, or uint32s when the vocabulary
+// has more than 65536 tokens.
+const (
+	tokenFileMagic   = 0x4B544C47 // "GLTK"
+	tokenFileVersion = 1
+	tokenHeaderSize  = 16
+)
+
+// TokenWriter writes a token file
+type TokenWriter struct {
+	path  string
+	file  *os.File
+	w     *bufio.Writer
+	width int
+	vocab int
+	buf   []byte
+	count int
+}
+
+// CreateTokenFile starts a token file for IDs below vocabSize. The file is
+// written next to path and only replaces it on Close.
+func CreateTokenFile(path string, vocabSize int) (*TokenWriter, error) {
+	if vocabSize <= 0 {
+		return nil, fmt.Errorf("invalid vocabulary size %d", vocabSize)
+	}
+	width := 2
+	if vocabSize > 1<<16 {
+		width = 4
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	tw := &TokenWriter{path: path, file: f, w: bufio.NewWriter(f), width: width, vocab: vocabSize}
+
+	var header [tokenHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], tokenFileMagic)
+	binary.LittleEndian.PutUint32(header[4:], tokenFileVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(width))
+	binary.LittleEndian.PutUint32(header[12:], uint32(vocabSize))
+	if _, err := tw.w.Write(header[:]); err != nil {
+		tw.Abort()
+		return nil, err
+	}
+	return tw, nil
+}
+
+// Write appends token IDs to the file
+func (tw *TokenWriter) Write(ids []int) error {
+	tw.buf = tw.buf[:0]
+	for _, id := range ids {
+		if id < 0 || id >= tw.vocab {
+			return fmt.Errorf("token %d outside vocabulary of %d", id, tw.vocab)
+		}
+		if tw.width == 2 {
+			tw.buf = binary.LittleEndian.AppendUint16(tw.buf, uint16(id))
+		} else {
+			tw.buf = binary.LittleEndian.AppendUint32(tw.buf, uint32(id))
+		}
+	}
+	tw.count += len(ids)
+	_, err := tw.w.Write(tw.buf)
+	return err
+}
+
+// Count returns the number of tokens written so far
+func (tw *TokenWriter) Count() int {
+	return tw.count
+}
+
+// Close finishes the file and moves it into place
+func (tw *TokenWriter) Close() error {
+	if err := tw.w.Flush(); err != nil {
+		tw.Abort()
+		return err
+	}
+	if err := tw.file.Chmod(0644); err != nil {
+		tw.Abort()
+		return err
+	}
+	if err := tw.file.Close(); err != nil {
+		os.Remove(tw.file.Name())
+		return err
+	}
+	return os.Rename(tw.file.Name(), tw.path)
+}
+
+// Abort discards the partly written file
+func (tw *TokenWriter) Abort() {
+	tw.file.Close()
+	os.Remove(tw.file.Name())
+}
+
+// TokenFile reads a token file. Tokens are read from disk on demand, so the
+// file may be larger than memory.
+type TokenFile struct {
+	file  *os.File
+	width int
+	vocab int
+	len   int
+	buf   []byte
+}
+
+// OpenTokenFile opens a token file written by TokenWriter
+func OpenTokenFile(path string) (*TokenFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := readTokenHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tf, nil
+}
+
+func readTokenHeader(f *os.File) (*TokenFile, error) {
+	var header [tokenHeaderSize]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read token file header: %v", err)
+	}
+	if magic := binary.LittleEndian.Uint32(header[0:]); magic != tokenFileMagic {
+		return nil, fmt.Errorf("not a token file")
+	}
+	if version := binary.LittleEndian.Uint32(header[4:]); version != tokenFileVersion {
+		return nil, fmt.Errorf("unsupported token file version %d", version)
+	}
+	width := int(binary.LittleEndian.Uint32(header[8:]))
+	if width != 2 && width != 4 {
+		return nil, fmt.Errorf("invalid token width %d", width)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size() - tokenHeaderSize
+	if size%int64(width) != 0 {
+		return nil, fmt.Errorf("token data of %d bytes is not a whole number of tokens", size)
+	}
+	return &TokenFile{
+		file:  f,
+		width: width,
+		vocab: int(binary.LittleEndian.Uint32(header[12:])),
+		len:   int(size / int64(width)),
+	}, nil
+}
+
+// IsTokenFile reports whether path starts with a token file header
+func IsTokenFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(magic[:]) == tokenFileMagic
+}
+
+// Len returns the number of tokens in the file
+func (tf *TokenFile) Len() int {
+	return tf.len
+}
+
+// VocabSize returns the vocabulary size the file was written for
+func (tf *TokenFile) VocabSize() int {
+	return tf.vocab
+}
+
+// Read returns the n tokens starting at token start, reusing dst if it is
+// large enough. It is not safe for concurrent use.
+func (tf *TokenFile) Read(start, n int, dst []int) ([]int, error) {
+	if start < 0 || n < 0 || start+n > tf.len {
+		return nil, fmt.Errorf("tokens [%d, %d) out of range [0, %d)", start, start+n, tf.len)
+	}
+	if cap(tf.buf) < n*tf.width {
+		tf.buf = make([]byte, n*tf.width)
+	}
+	buf := tf.buf[:n*tf.width]
+	if _, err := tf.file.ReadAt(buf, tokenHeaderSize+int64(start)*int64(tf.width)); err != nil {
+		return nil, err
+	}
+
+	dst = dst[:0]
+	for i := 0; i < n; i++ {
+		if tf.width == 2 {
+			dst = append(dst, int(binary.LittleEndian.Uint16(buf[2*i:])))
+		} else {
+			dst = append(dst, int(binary.LittleEndian.Uint32(buf[4*i:])))
+		}
+	}
+	return dst, nil
+}
+
+// Close closes the file
+func (tf *TokenFile) Close() error {
+	return tf.file.Close()
+}
@@ -0,0 +1,80 @@
+package corpus
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTokenFile_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		vocabSize int
+		width     int
+		ids       []int
+	}{
+		{"uint16", 50257, 2, []int{0, 1, 50256, 17, 42}},
+		{"uint32", 100000, 4, []int{0, 99999, 65536, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "corpus.tok")
+			w, err := CreateTokenFile(path, tt.vocabSize)
+			if err != nil {
+				t.Fatalf("CreateTokenFile() error = %v", err)
+			}
+			if err := w.Write(tt.ids[:2]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := w.Write(tt.ids[2:]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if !IsTokenFile(path) {
+				t.Fatalf("IsTokenFile() = false")
+			}
+			tf, err := OpenTokenFile(path)
+			if err != nil {
+				t.Fatalf("OpenTokenFile() error = %v", err)
+			}
+			defer tf.Close()
+
+			if tf.width != tt.width || tf.VocabSize() != tt.vocabSize || tf.Len() != len(tt.ids) {
+				t.Errorf("width %d, vocab %d, len %d; want %d, %d, %d",
+					tf.width, tf.VocabSize(), tf.Len(), tt.width, tt.vocabSize, len(tt.ids))
+			}
+			got, err := tf.Read(0, tf.Len(), nil)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.ids) {
+				t.Errorf("Read() = %v, want %v", got, tt.ids)
+			}
+			if got, _ := tf.Read(1, 2, got); !reflect.DeepEqual(got, tt.ids[1:3]) {
+				t.Errorf("Read(1, 2) = %v, want %v", got, tt.ids[1:3])
+			}
+			if _, err := tf.Read(tf.Len()-1, 2, nil); err == nil {
+				t.Errorf("Read() past the end succeeded")
+			}
+		})
+	}
+}
+
+func TestTokenWriter_RejectsOutOfVocabulary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.tok")
+	w, err := CreateTokenFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abort()
+	if err := w.Write([]int{3, 10}); err == nil {
+		t.Errorf("Write() of an ID outside the vocabulary succeeded")
+	}
+	if IsTokenFile(path) {
+		t.Errorf("token file exists before Close")
+	}
+}
@@ -3,67 +3,140 @@ package model
 import (
 	"fmt"
 	"math"
+	"math/rand"
 )
 
 type MultiHeadAttention struct {
 	NumHeads int
 	HeadDim  int
-	QKVProj  *Linear
+	QKVProj  *Linear // queries, then keys, then values
 	OutProj  *Linear
+
+	// NumKVHeads is the number of key and value heads. Each is shared by
+	// NumHeads/NumKVHeads consecutive query heads: grouped-query attention,
+	// or multi-query attention with a single key and value head.
+	NumKVHeads int
+
+	// Causal restricts every position to attend only to itself and earlier
+	// positions, as required for autoregressive language modeling
+	Causal bool
+
+	// Rotary, when set, rotates queries and keys by their position (RoPE)
+	Rotary *Rotary
+	// ALiBiSlopes, when set, holds the slope of each head's penalty on the
+	// distance between query and key (ALiBi)
+	ALiBiSlopes []float32
+
+	// Activations of the last Forward call, kept for Backward. q, k and v
+	// are column views of the QKV projection output.
+	q, k, v *Tensor
+	probs   *Tensor // [head, query, key]
 }
 
-func NewMultiHeadAttention(embedDim, numHeads int) *MultiHeadAttention {
+func NewMultiHeadAttention(embedDim, numHeads, numKVHeads int, rng *rand.Rand) *MultiHeadAttention {
 	headDim := embedDim / numHeads
 	if embedDim%numHeads != 0 {
 		panic(fmt.Sprintf("embedDim (%d) must be divisible by numHeads (%d)", embedDim, numHeads))
 	}
+	if numKVHeads <= 0 || numHeads%numKVHeads != 0 {
+		panic(fmt.Sprintf("numHeads (%d) must be divisible by numKVHeads (%d)", numHeads, numKVHeads))
+	}
 	return &MultiHeadAttention{
-		NumHeads: numHeads,
-		HeadDim:  headDim,
-		QKVProj:  NewLinear(embedDim, 3*embedDim),
-		OutProj:  NewLinear(embedDim, embedDim),
+		NumHeads:   numHeads,
+		HeadDim:    headDim,
+		NumKVHeads: numKVHeads,
+		QKVProj:    NewLinear(embedDim, embedDim+2*numKVHeads*headDim, rng),
+		OutProj:    NewLinear(embedDim, embedDim, rng),
 	}
 }
 
-func (mha *MultiHeadAttention) Forward(x [][]float32) [][]float32 {
-	batchSize := len(x)
-	embedDim := len(x[0])
-
-	qkv := mha.QKVProj.Forward(x)
+// kvDim returns the width of the keys, and of the values, of one position
+func (mha *MultiHeadAttention) kvDim() int {
+	return mha.NumKVHeads * mha.HeadDim
+}
 
-	q := make([][]float32, batchSize)
-	k := make([][]float32, batchSize)
-	v := make([][]float32, batchSize)
+// splitQKV returns column views of the queries, keys and values in the
+// output of QKVProj
+func (mha *MultiHeadAttention) splitQKV(qkv *Tensor) (q, k, v *Tensor) {
+	embedDim, kvDim := mha.NumHeads*mha.HeadDim, mha.kvDim()
+	return qkv.Slice(1, 0, embedDim),
+		qkv.Slice(1, embedDim, embedDim+kvDim),
+		qkv.Slice(1, embedDim+kvDim, embedDim+2*kvDim)
+}
 
-	for i := range qkv {
-		q[i] = make([]float32, embedDim)
-		k[i] = make([]float32, embedDim)
-		v[i] = make([]float32, embedDim)
-		copy(q[i], qkv[i][:embedDim])
-		copy(k[i], qkv[i][embedDim:2*embedDim])
-		copy(v[i], qkv[i][2*embedDim:])
+// Forward applies self-attention over the sequence x. mask is optional: when
+// non-nil, positions with mask[i] == false are treated as padding and cannot
+// be attended to, so sequences of different lengths can be padded to a
+// common length. Rows with no position left to attend to produce zeros.
+func (mha *MultiHeadAttention) Forward(x *Tensor, mask []bool) *Tensor {
+	mha.q, mha.k, mha.v = mha.splitQKV(mha.QKVProj.Forward(x))
+	if mha.Rotary != nil {
+		mha.Rotary.rotate(mha.q, 0, false)
+		mha.Rotary.rotate(mha.k, 0, false)
 	}
 
-	output := make([][]float32, batchSize)
-	for i := range output {
-		output[i] = make([]float32, embedDim)
+	var output *Tensor
+	output, mha.probs = mha.attend(mha.q, mha.k, mha.v, 0, mask)
+	return mha.OutProj.Forward(output)
+}
+
+// ForwardCached applies self-attention to x, the positions following those
+// already held in cache, and appends their keys and values to the cache.
+// Only the new positions are projected, which makes token-by-token decoding
+// linear in the sequence length. Nothing is retained for Backward.
+func (mha *MultiHeadAttention) ForwardCached(x *Tensor, cache *LayerKVCache) *Tensor {
+	offset := cache.Len()
+
+	q, k, v := mha.splitQKV(mha.QKVProj.Forward(x))
+	if mha.Rotary != nil {
+		mha.Rotary.rotate(q, offset, false)
+		mha.Rotary.rotate(k, offset, false)
+	}
+	for i := 0; i < x.Rows(); i++ {
+		cache.Keys = append(cache.Keys, k.Row(i)...)
+		cache.Values = append(cache.Values, v.Row(i)...)
 	}
+	cache.Dim = mha.kvDim()
+
+	output, _ := mha.attend(q, cache.keys(), cache.values(), offset, nil)
+	return mha.OutProj.Forward(output)
+}
+
+// attend computes the per-head softmax attention of queries q over keys k and
+// values v. The queries sit at positions offset, offset+1, ... of the key
+// sequence, which matters for the causal mask and the ALiBi penalty. It
+// returns the concatenated head outputs and the attention weights as
+// [head, query, key].
+func (mha *MultiHeadAttention) attend(q, k, v *Tensor, offset int, mask []bool) (*Tensor, *Tensor) {
+	numQueries, numKeys := q.Rows(), k.Rows()
+	output := NewTensor(numQueries, mha.NumHeads*mha.HeadDim)
+	probs := NewTensor(mha.NumHeads, numQueries, numKeys)
 
+	scale := 1.0 / float32(math.Sqrt(float64(mha.HeadDim)))
+	group := mha.NumHeads / mha.NumKVHeads
 	for h := 0; h < mha.NumHeads; h++ {
 		start := h * mha.HeadDim
 		end := (h + 1) * mha.HeadDim
-		for b := range output {
-			qh := q[b][start:end]
-			scores := make([]float32, batchSize)
-			scale := 1.0 / float32(math.Sqrt(float64(mha.HeadDim)))
+		kvStart := h / group * mha.HeadDim
+		kvEnd := kvStart + mha.HeadDim
+		for b := 0; b < numQueries; b++ {
+			qh := q.Row(b)[start:end]
+			scores := probs.Data[(h*numQueries+b)*numKeys : (h*numQueries+b+1)*numKeys]
 
-			for i := 0; i < batchSize; i++ {
-				kh := k[i][start:end]
+			for i := 0; i < numKeys; i++ {
+				if (mha.Causal && i > offset+b) || (mask != nil && !mask[i]) {
+					scores[i] = float32(math.Inf(-1))
+					continue
+				}
+				kh := k.Row(i)[kvStart:kvEnd]
 				sum := float32(0)
-				for j := 0; j < mha.HeadDim; j++ {
+				for j := range qh {
 					sum += qh[j] * kh[j]
 				}
 				scores[i] = sum * scale
+				if mha.ALiBiSlopes != nil {
+					scores[i] -= mha.ALiBiSlopes[h] * float32(abs(offset+b-i))
+				}
 			}
 
 			maxScore := float32(math.Inf(-1))
@@ -74,22 +147,149 @@ func (mha *MultiHeadAttention) Forward(x [][]float32) [][]float32 {
 			}
 
 			expSum := float32(0)
-			expScores := make([]float32, batchSize)
 			for i, score := range scores {
-				expScores[i] = float32(math.Exp(float64(score - maxScore)))
-				expSum += expScores[i]
+				if math.IsInf(float64(score), -1) {
+					scores[i] = 0
+					continue
+				}
+				scores[i] = float32(math.Exp(float64(score - maxScore)))
+				expSum += scores[i]
+			}
+			if expSum > 0 {
+				for i := range scores {
+					scores[i] /= expSum
+				}
+			}
+
+			out := output.Row(b)[start:end]
+			for i, p := range scores {
+				if p == 0 {
+					continue
+				}
+				vh := v.Row(i)[kvStart:kvEnd]
+				for j := range out {
+					out[j] += p * vh[j]
+				}
 			}
+		}
+	}
+
+	return output, probs
+}
+
+// Backward propagates dOut through the output projection, the per-head
+// softmax attention and the QKV projection, accumulating the gradients of
+// both projections, and returns the gradient of the input.
+func (mha *MultiHeadAttention) Backward(dOut *Tensor) *Tensor {
+	dAttn := mha.OutProj.Backward(dOut)
+
+	batchSize := dAttn.Rows()
+	scale := 1.0 / float32(math.Sqrt(float64(mha.HeadDim)))
 
-			for j := 0; j < mha.HeadDim; j++ {
+	// Query heads sharing a key and value head add up their gradients
+	dqkv := NewTensor(batchSize, mha.QKVProj.OutFeatures)
+	dq, dk, dv := mha.splitQKV(dqkv)
+	dProbs := make([]float32, batchSize)
+
+	group := mha.NumHeads / mha.NumKVHeads
+	for h := 0; h < mha.NumHeads; h++ {
+		start := h * mha.HeadDim
+		end := (h + 1) * mha.HeadDim
+		kvStart := h / group * mha.HeadDim
+		kvEnd := kvStart + mha.HeadDim
+		for b := 0; b < batchSize; b++ {
+			probs := mha.probs.Data[(h*batchSize+b)*batchSize : (h*batchSize+b+1)*batchSize]
+			dOutH := dAttn.Row(b)[start:end]
+
+			// Gradient of the attention weights and of the values
+			var weighted float32
+			for i := 0; i < batchSize; i++ {
+				vh := mha.v.Row(i)[kvStart:kvEnd]
+				dvh := dv.Row(i)[kvStart:kvEnd]
 				sum := float32(0)
-				for i := 0; i < batchSize; i++ {
-					vh := v[i][start:end]
-					sum += expScores[i] * vh[j] / expSum
+				for j := range dOutH {
+					sum += dOutH[j] * vh[j]
+					dvh[j] += probs[i] * dOutH[j]
+				}
+				dProbs[i] = sum
+				weighted += probs[i] * sum
+			}
+
+			// Softmax backward, then into the scaled dot products
+			qh := mha.q.Row(b)[start:end]
+			dqh := dq.Row(b)[start:end]
+			for i := 0; i < batchSize; i++ {
+				dScore := probs[i] * (dProbs[i] - weighted) * scale
+				if dScore == 0 {
+					continue
+				}
+				kh := mha.k.Row(i)[kvStart:kvEnd]
+				dkh := dk.Row(i)[kvStart:kvEnd]
+				for j := range qh {
+					dqh[j] += dScore * kh[j]
+					dkh[j] += dScore * qh[j]
 				}
-				output[b][start+j] = sum
 			}
 		}
 	}
 
-	return mha.OutProj.Forward(output)
+	if mha.Rotary != nil {
+		mha.Rotary.rotate(dq, 0, true)
+		mha.Rotary.rotate(dk, 0, true)
+	}
+	return mha.QKVProj.Backward(dqkv)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (mha *MultiHeadAttention) parameters(prefix string) []*Parameter {
+	return append(mha.QKVProj.parameters(prefix+"qkv_proj"), mha.OutProj.parameters(prefix+"out_proj")...)
+}
+
+// LayerKVCache holds the keys and values of every position already processed
+// by one attention layer during incremental decoding, one row of Dim values
+// per position
+type LayerKVCache struct {
+	Keys   []float32
+	Values []float32
+	Dim    int
+}
+
+// Len returns the number of cached positions
+func (c *LayerKVCache) Len() int {
+	if c.Dim == 0 {
+		return 0
+	}
+	return len(c.Keys) / c.Dim
+}
+
+func (c *LayerKVCache) keys() *Tensor {
+	return TensorFrom(c.Keys, c.Len(), c.Dim)
+}
+
+func (c *LayerKVCache) values() *Tensor {
+	return TensorFrom(c.Values, c.Len(), c.Dim)
+}
+
+// Truncate drops every cached position from n onwards
+func (c *LayerKVCache) Truncate(n int) {
+	if n < c.Len() {
+		c.Keys = c.Keys[: n*c.Dim : n*c.Dim]
+		c.Values = c.Values[: n*c.Dim : n*c.Dim]
+	}
+}
+
+// Clone returns a cache that can grow independently of c. The cached rows are
+// shared, not copied; extending either cache never affects the other.
+func (c *LayerKVCache) Clone() *LayerKVCache {
+	return &LayerKVCache{
+		Keys:   c.Keys[:len(c.Keys):len(c.Keys)],
+		Values: c.Values[:len(c.Values):len(c.Values)],
+		Dim:    c.Dim,
+	}
 }
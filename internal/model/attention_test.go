@@ -0,0 +1,78 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMultiHeadAttention_PaddingMask(t *testing.T) {
+	mha := NewMultiHeadAttention(8, 2, 2, testRand())
+	x := NewTensor(5, 8)
+	for i := range x.Data {
+		x.Data[i] = float32(math.Sin(float64(i)))
+	}
+
+	want := mha.Forward(x.Slice(0, 0, 3), nil)
+	got := mha.Forward(x, []bool{true, true, true, false, false})
+
+	for i := 0; i < want.Rows(); i++ {
+		for j, w := range want.Row(i) {
+			if diff := math.Abs(float64(got.Row(i)[j] - w)); diff > 1e-6 {
+				t.Fatalf("row %d col %d: padded output %g, unpadded %g", i, j, got.Row(i)[j], w)
+			}
+		}
+	}
+}
+
+func TestMultiHeadAttention_GroupedQueryMatchesSharedHeads(t *testing.T) {
+	const embedDim, numHeads = 8, 4
+	gqa := NewMultiHeadAttention(embedDim, numHeads, 2, testRand())
+	gqa.Causal = true
+
+	// Full multi-head attention whose key and value heads repeat those of
+	// gqa for each pair of query heads
+	mha := NewMultiHeadAttention(embedDim, numHeads, numHeads, testRand())
+	mha.Causal = true
+	mha.OutProj = gqa.OutProj
+	headDim, kvDim := embedDim/numHeads, 2*embedDim/numHeads
+	copyRow := func(dst, src int) {
+		copy(mha.QKVProj.Weight.Row(dst), gqa.QKVProj.Weight.Row(src))
+		mha.QKVProj.Bias.Data[dst] = gqa.QKVProj.Bias.Data[src]
+	}
+	for r := 0; r < embedDim; r++ {
+		copyRow(r, r)
+	}
+	for h := 0; h < numHeads; h++ {
+		for j := 0; j < headDim; j++ {
+			kv := h/2*headDim + j
+			copyRow(embedDim+h*headDim+j, embedDim+kv)
+			copyRow(2*embedDim+h*headDim+j, embedDim+kvDim+kv)
+		}
+	}
+
+	x := NewTensor(5, embedDim)
+	for i := range x.Data {
+		x.Data[i] = float32(math.Sin(float64(i)))
+	}
+	want := mha.Forward(x, nil)
+	got := gqa.Forward(x, nil)
+	for i := range want.Data {
+		if diff := math.Abs(float64(got.Data[i] - want.Data[i])); diff > 1e-6 {
+			t.Fatalf("output[%d] = %g, want %g", i, got.Data[i], want.Data[i])
+		}
+	}
+}
+
+func TestMultiHeadAttention_FullyMaskedRowIsZero(t *testing.T) {
+	mha := NewMultiHeadAttention(4, 1, 1, testRand())
+	mha.Causal = true
+	x := TensorFrom([]float32{1, 2, 3, 4, 4, 3, 2, 1}, 2, 4)
+
+	// With left padding the first query has nothing to attend to
+	out := mha.Forward(x, []bool{false, true})
+	for j, v := range out.Row(0) {
+		if v != mha.OutProj.Bias.Data[j] || math.IsNaN(float64(v)) {
+			t.Fatalf("fully masked row should only carry the output bias, got %v", out.Row(0))
+		}
+	}
+}
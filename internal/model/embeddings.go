@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"math/rand"
 	"sync"
 )
@@ -9,57 +10,42 @@ type Embeddings struct {
 	VocabSize     int
 	EmbedDim      int
 	ContextSize   int
-	TokenEmbed    [][]float32
-	PositionEmbed [][]float32
+	TokenEmbed    *Tensor // [VocabSize, EmbedDim]
+	PositionEmbed *Tensor // [ContextSize, EmbedDim], nil without learned positions
+
+	// Gradients accumulated by Backward, allocated on first use
+	TokenEmbedGrad    *Tensor
+	PositionEmbedGrad *Tensor
+
+	// Rows read by the last Lookup and PositionLookup calls
+	tokens    []int
+	positions []int
 }
 
-func NewEmbeddings(vocabSize, embedDim, contextSize int) *Embeddings {
+// NewEmbeddings returns token embeddings and, when contextSize is positive,
+// learned embeddings for positions below contextSize
+func NewEmbeddings(vocabSize, embedDim, contextSize int, rng *rand.Rand) *Embeddings {
 	e := &Embeddings{
-		VocabSize:     vocabSize,
-		EmbedDim:      embedDim,
-		ContextSize:   contextSize,
-		TokenEmbed:    make([][]float32, vocabSize),
-		PositionEmbed: make([][]float32, contextSize),
+		VocabSize:   vocabSize,
+		EmbedDim:    embedDim,
+		ContextSize: contextSize,
+		TokenEmbed:  NewTensor(vocabSize, embedDim),
 	}
 
+	// Filled sequentially so the weights depend only on the state of rng
 	scale := float32(0.02)
-
-	var wg sync.WaitGroup
-	numWorkers := 4
-	tokensPerWorker := (vocabSize + numWorkers - 1) / numWorkers
-
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Add(-1)
-			startToken := workerID * tokensPerWorker
-			endToken := (workerID + 1) * tokensPerWorker
-			if endToken > vocabSize {
-				endToken = vocabSize
-			}
-
-			for i := startToken; i < endToken; i++ {
-				e.TokenEmbed[i] = make([]float32, embedDim)
-				for j := range e.TokenEmbed[i] {
-					e.TokenEmbed[i][j] = (rand.Float32()*2 - 1) * scale
-				}
-			}
-		}(w)
-	}
-	wg.Wait()
-
-	for i := range e.PositionEmbed {
-		e.PositionEmbed[i] = make([]float32, embedDim)
-		for j := range e.PositionEmbed[i] {
-			e.PositionEmbed[i][j] = (rand.Float32()*2 - 1) * scale
-		}
+	uniformInit(e.TokenEmbed, scale, rng)
+	if contextSize > 0 {
+		e.PositionEmbed = NewTensor(contextSize, embedDim)
+		uniformInit(e.PositionEmbed, scale, rng)
 	}
 
 	return e
 }
 
-func (e *Embeddings) Lookup(tokens []int) [][]float32 {
-	result := make([][]float32, len(tokens))
+func (e *Embeddings) Lookup(tokens []int) *Tensor {
+	result := NewTensor(len(tokens), e.EmbedDim)
+	e.tokens = tokens
 
 	var wg sync.WaitGroup
 	wg.Add(len(tokens))
@@ -70,8 +56,7 @@ func (e *Embeddings) Lookup(tokens []int) [][]float32 {
 			if tok >= e.VocabSize {
 				tok = 0
 			}
-			result[idx] = make([]float32, e.EmbedDim)
-			copy(result[idx], e.TokenEmbed[tok])
+			copy(result.Row(idx), e.TokenEmbed.Row(tok))
 		}(i, token)
 	}
 	wg.Wait()
@@ -79,8 +64,16 @@ func (e *Embeddings) Lookup(tokens []int) [][]float32 {
 	return result
 }
 
-func (e *Embeddings) PositionLookup(positions []int) [][]float32 {
-	result := make([][]float32, len(positions))
+// PositionLookup returns the learned embeddings of positions, which must be
+// below ContextSize
+func (e *Embeddings) PositionLookup(positions []int) (*Tensor, error) {
+	for _, p := range positions {
+		if p < 0 || p >= e.ContextSize {
+			return nil, fmt.Errorf("position %d beyond the %d learned positions", p, e.ContextSize)
+		}
+	}
+	result := NewTensor(len(positions), e.EmbedDim)
+	e.positions = positions
 
 	var wg sync.WaitGroup
 	wg.Add(len(positions))
@@ -88,14 +81,53 @@ func (e *Embeddings) PositionLookup(positions []int) [][]float32 {
 	for i, pos := range positions {
 		go func(idx, p int) {
 			defer wg.Add(-1)
-			if p >= len(e.PositionEmbed) {
-				p = len(e.PositionEmbed) - 1
-			}
-			result[idx] = make([]float32, e.EmbedDim)
-			copy(result[idx], e.PositionEmbed[p])
+			copy(result.Row(idx), e.PositionEmbed.Row(p))
 		}(i, pos)
 	}
 	wg.Wait()
 
-	return result
+	return result, nil
+}
+
+// Backward scatters dOut, the gradient of the summed token and position
+// embeddings, into the rows read by the last Lookup and PositionLookup calls.
+// Without learned positions only the token embeddings receive gradients.
+func (e *Embeddings) Backward(dOut *Tensor) {
+	e.ensureGrads()
+
+	for i := 0; i < dOut.Rows(); i++ {
+		tok := e.tokens[i]
+		if tok >= e.VocabSize {
+			tok = 0
+		}
+		tokGrad := e.TokenEmbedGrad.Row(tok)
+		for j, g := range dOut.Row(i) {
+			tokGrad[j] += g
+		}
+
+		if e.PositionEmbed != nil {
+			posGrad := e.PositionEmbedGrad.Row(e.positions[i])
+			for j, g := range dOut.Row(i) {
+				posGrad[j] += g
+			}
+		}
+	}
+}
+
+func (e *Embeddings) ensureGrads() {
+	if e.TokenEmbedGrad == nil {
+		e.TokenEmbedGrad = NewTensor(e.TokenEmbed.Shape...)
+	}
+	if e.PositionEmbed != nil && e.PositionEmbedGrad == nil {
+		e.PositionEmbedGrad = NewTensor(e.PositionEmbed.Shape...)
+	}
+}
+
+func (e *Embeddings) parameters() []*Parameter {
+	e.ensureGrads()
+	params := []*Parameter{{Name: "token_embeddings", Value: e.TokenEmbed, Grad: e.TokenEmbedGrad}}
+	if e.PositionEmbed != nil {
+		params = append(params, &Parameter{Name: "position_embeddings", Value: e.PositionEmbed, Grad: e.PositionEmbedGrad})
+	}
+	return params
 }
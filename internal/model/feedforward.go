@@ -1,36 +1,163 @@
 package model
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
 
+// Values of Config.Activation
+const (
+	// ActivationGELUTanh is the tanh approximation of GELU used by GPT-2
+	ActivationGELUTanh = "gelu_tanh"
+	// ActivationGELU is the exact GELU, x * Phi(x), computed with erf
+	ActivationGELU = "gelu"
+	ActivationReLU = "relu"
+	// ActivationSiLU is x * sigmoid(x), also known as swish
+	ActivationSiLU = "silu"
+
+	// The gated linear units project to a gate and a value of the hidden
+	// size and multiply the activated gate with the value
+	ActivationSwiGLU = "swiglu" // SiLU gate
+	ActivationGeGLU  = "geglu"  // GELU gate
+	ActivationReGLU  = "reglu"  // ReLU gate
+)
+
+// activationFunc is an elementwise activation and its derivative
+type activationFunc struct {
+	apply func(float64) float64
+	grad  func(float64) float64
+}
+
+var activationFuncs = map[string]activationFunc{
+	ActivationGELUTanh: {geluTanh, geluTanhGrad},
+	ActivationGELU:     {geluErf, geluErfGrad},
+	ActivationReLU:     {relu, reluGrad},
+	ActivationSiLU:     {silu, siluGrad},
+}
+
+// gateActivations maps each gated unit to the activation of its gate
+var gateActivations = map[string]string{
+	ActivationSwiGLU: ActivationSiLU,
+	ActivationGeGLU:  ActivationGELU,
+	ActivationReGLU:  ActivationReLU,
+}
+
+// FeedForward is the position-wise MLP of a transformer block: fc1, an
+// activation and fc2. For gated activations fc1 outputs the gate followed by
+// the value, each of the hidden size.
 type FeedForward struct {
 	fc1 *Linear
 	fc2 *Linear
+
+	act   activationFunc
+	gated bool
+
+	// Pre-activation hidden state of the last Forward call
+	hidden *Tensor
 }
 
-func NewFeedForward(embedDim int) *FeedForward {
-	return &FeedForward{
-		fc1: NewLinear(embedDim, 4*embedDim),
-		fc2: NewLinear(4*embedDim, embedDim),
+// NewFeedForward creates an MLP with hiddenDim hidden units and one of the
+// Activation values
+func NewFeedForward(embedDim, hiddenDim int, activation string, rng *rand.Rand) *FeedForward {
+	ff := &FeedForward{}
+	if gate, ok := gateActivations[activation]; ok {
+		ff.act, ff.gated = activationFuncs[gate], true
+	} else if act, ok := activationFuncs[activation]; ok {
+		ff.act = act
+	} else {
+		panic(fmt.Sprintf("unknown activation %q", activation))
 	}
+
+	fc1Out := hiddenDim
+	if ff.gated {
+		fc1Out = 2 * hiddenDim
+	}
+	ff.fc1 = NewLinear(embedDim, fc1Out, rng)
+	ff.fc2 = NewLinear(hiddenDim, embedDim, rng)
+	return ff
 }
 
-func (ff *FeedForward) Forward(x [][]float32) [][]float32 {
+func (ff *FeedForward) Forward(x *Tensor) *Tensor {
 	x = ff.fc1.Forward(x)
-	x = gelu(x)
-	return ff.fc2.Forward(x)
+	ff.hidden = x
+
+	hiddenDim := ff.fc2.InFeatures
+	activated := NewTensor(x.Rows(), hiddenDim)
+	for i := 0; i < x.Rows(); i++ {
+		row, out := x.Row(i), activated.Row(i)
+		for j := range out {
+			out[j] = float32(ff.act.apply(float64(row[j])))
+			if ff.gated {
+				out[j] *= row[hiddenDim+j]
+			}
+		}
+	}
+	return ff.fc2.Forward(activated)
 }
 
-// Gaussian Error Linear Unit approximation
-func gelu(x [][]float32) [][]float32 {
-	result := make([][]float32, len(x))
-
-	for i := range x {
-		result[i] = make([]float32, len(x[i]))
-		for j := range x[i] {
-			result[i][j] = 0.5 * x[i][j] * (1 + float32(math.Tanh(
-				math.Sqrt(2/math.Pi)*(float64(x[i][j])+0.044715*math.Pow(float64(x[i][j]), 3)),
-			)))
+// Backward propagates dOut through both projections and the activation,
+// accumulating their gradients, and returns the gradient of the input.
+func (ff *FeedForward) Backward(dOut *Tensor) *Tensor {
+	dActivated := ff.fc2.Backward(dOut)
+
+	hiddenDim := ff.fc2.InFeatures
+	dHidden := NewTensor(ff.hidden.Shape...)
+	for i := 0; i < dHidden.Rows(); i++ {
+		row, dRow, dOutRow := ff.hidden.Row(i), dHidden.Row(i), dActivated.Row(i)
+		for j, dy := range dOutRow {
+			v := float64(row[j])
+			if ff.gated {
+				value := row[hiddenDim+j]
+				dRow[j] = dy * value * float32(ff.act.grad(v))
+				dRow[hiddenDim+j] = dy * float32(ff.act.apply(v))
+			} else {
+				dRow[j] = dy * float32(ff.act.grad(v))
+			}
 		}
 	}
-	return result
+	return ff.fc1.Backward(dHidden)
+}
+
+func (ff *FeedForward) parameters(prefix string) []*Parameter {
+	return append(ff.fc1.parameters(prefix+"ff1"), ff.fc2.parameters(prefix+"ff2")...)
+}
+
+// Gaussian Error Linear Unit approximation
+func geluTanh(v float64) float64 {
+	return 0.5 * v * (1 + math.Tanh(math.Sqrt(2/math.Pi)*(v+0.044715*v*v*v)))
+}
+
+func geluTanhGrad(v float64) float64 {
+	c := math.Sqrt(2 / math.Pi)
+	t := math.Tanh(c * (v + 0.044715*v*v*v))
+	return 0.5*(1+t) + 0.5*v*(1-t*t)*c*(1+3*0.044715*v*v)
+}
+
+func geluErf(v float64) float64 {
+	return 0.5 * v * (1 + math.Erf(v/math.Sqrt2))
+}
+
+func geluErfGrad(v float64) float64 {
+	return 0.5*(1+math.Erf(v/math.Sqrt2)) + v*math.Exp(-v*v/2)/math.Sqrt(2*math.Pi)
+}
+
+func relu(v float64) float64 {
+	return math.Max(v, 0)
+}
+
+func reluGrad(v float64) float64 {
+	if v > 0 {
+		return 1
+	}
+	return 0
+}
+
+func silu(v float64) float64 {
+	return v / (1 + math.Exp(-v))
+}
+
+func siluGrad(v float64) float64 {
+	s := 1 / (1 + math.Exp(-v))
+	return s * (1 + v*(1-s))
 }
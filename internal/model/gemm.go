@@ -0,0 +1,168 @@
+package model
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Tile sizes of the blocked kernels. A tile of C is computed by one worker;
+// the inner dimension is walked in blocks so the rows of A and B being
+// combined stay in cache.
+const (
+	tileRows = 32
+	tileCols = 64
+	blockK   = 256
+
+	// Products with fewer multiply-adds than this run on the calling goroutine
+	parallelThreshold = 1 << 15
+)
+
+var numThreads atomic.Int32
+
+func init() {
+	numThreads.Store(int32(runtime.NumCPU()))
+}
+
+// SetNumThreads sets how many goroutines matrix multiplications may use.
+// Values below 1 restore the default of runtime.NumCPU().
+func SetNumThreads(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	numThreads.Store(int32(n))
+}
+
+// NumThreads returns the number of goroutines used by matrix multiplications
+func NumThreads() int {
+	return int(numThreads.Load())
+}
+
+// MatMul returns the product a·b of two 2-D tensors. Either operand may be a
+// transposed view, such as a Linear weight stored [out, in] used as [in, out].
+func MatMul(a, b *Tensor) *Tensor {
+	c := NewTensor(a.Shape[0], b.Shape[1])
+	matMulAdd(c, a, b)
+	return c
+}
+
+// matMulAdd accumulates a·b into the contiguous tensor c
+func matMulAdd(c, a, b *Tensor) {
+	if len(a.Shape) != 2 || len(b.Shape) != 2 || a.Shape[1] != b.Shape[0] ||
+		c.Shape[0] != a.Shape[0] || c.Shape[1] != b.Shape[1] {
+		panic(fmt.Sprintf("matMul dimension mismatch: a%v, b%v, c%v", a.Shape, b.Shape, c.Shape))
+	}
+
+	// The kernels need rows of a contiguous; a transposed a is small enough
+	// in practice (activations) that compacting it is cheap.
+	if a.Strides[1] != 1 {
+		a = a.Clone()
+	}
+
+	m, n, k := a.Shape[0], b.Shape[1], a.Shape[1]
+	if m == 0 || n == 0 {
+		return
+	}
+
+	kernel := matMulTileNN
+	if b.Strides[0] == 1 && b.Shape[0] > 1 {
+		// b is the transpose of a row-major matrix: every element of c is a
+		// dot product of two contiguous rows
+		kernel = matMulTileNT
+		b = b.Transpose()
+	} else if b.Strides[1] != 1 {
+		b = b.Clone()
+	}
+
+	rowTiles := (m + tileRows - 1) / tileRows
+	colTiles := (n + tileCols - 1) / tileCols
+	numTiles := rowTiles * colTiles
+
+	runTile := func(t int) {
+		i0 := (t / colTiles) * tileRows
+		j0 := (t % colTiles) * tileCols
+		kernel(c, a, b, i0, min(i0+tileRows, m), j0, min(j0+tileCols, n))
+	}
+
+	workers := min(NumThreads(), numTiles)
+	if workers <= 1 || m*n*k < parallelThreshold {
+		for t := 0; t < numTiles; t++ {
+			runTile(t)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				t := int(next.Add(1) - 1)
+				if t >= numTiles {
+					return
+				}
+				runTile(t)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// matMulTileNN accumulates rows i0..i1 and columns j0..j1 of a·b where b is
+// row-major [k, n]
+func matMulTileNN(c, a, b *Tensor, i0, i1, j0, j1 int) {
+	k := a.Shape[1]
+	for k0 := 0; k0 < k; k0 += blockK {
+		k1 := min(k0+blockK, k)
+		for i := i0; i < i1; i++ {
+			ai := a.Row(i)
+			ci := c.Row(i)[j0:j1]
+			for kk := k0; kk < k1; kk++ {
+				aik := ai[kk]
+				if aik == 0 {
+					continue
+				}
+				bk := b.Row(kk)[j0:j1]
+				for j, v := range bk {
+					ci[j] += aik * v
+				}
+			}
+		}
+	}
+}
+
+// matMulTileNT accumulates rows i0..i1 and columns j0..j1 of a·btᵀ where bt
+// is row-major [n, k]
+func matMulTileNT(c, a, bt *Tensor, i0, i1, j0, j1 int) {
+	k := a.Shape[1]
+	for k0 := 0; k0 < k; k0 += blockK {
+		k1 := min(k0+blockK, k)
+		for i := i0; i < i1; i++ {
+			ai := a.Row(i)[k0:k1]
+			ci := c.Row(i)
+			for j := j0; j < j1; j++ {
+				ci[j] += dot(ai, bt.Row(j)[k0:k1])
+			}
+		}
+	}
+}
+
+// dot returns the inner product of two equally long vectors
+func dot(x, y []float32) float32 {
+	y = y[:len(x)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(x); i += 4 {
+		s0 += x[i] * y[i]
+		s1 += x[i+1] * y[i+1]
+		s2 += x[i+2] * y[i+2]
+		s3 += x[i+3] * y[i+3]
+	}
+	for ; i < len(x); i++ {
+		s0 += x[i] * y[i]
+	}
+	return s0 + s1 + s2 + s3
+}
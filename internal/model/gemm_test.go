@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// naiveMatMul is the single-goroutine triple loop the kernels replace
+func naiveMatMul(a, b [][]float32) [][]float32 {
+	result := make([][]float32, len(a))
+	for i := range result {
+		result[i] = make([]float32, len(b[0]))
+		for j := range result[i] {
+			var sum float32
+			for k := range b {
+				sum += a[i][k] * b[k][j]
+			}
+			result[i][j] = sum
+		}
+	}
+	return result
+}
+
+func randomTensor(r *rand.Rand, rows, cols int) *Tensor {
+	t := NewTensor(rows, cols)
+	for i := range t.Data {
+		t.Data[i] = r.Float32()*2 - 1
+	}
+	return t
+}
+
+func TestMatMul_MatchesNaive(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	defer SetNumThreads(0)
+
+	for _, threads := range []int{1, 4} {
+		SetNumThreads(threads)
+		for _, dims := range [][3]int{{1, 300, 70}, {37, 260, 130}, {64, 64, 64}, {5, 3, 1}} {
+			m, k, n := dims[0], dims[1], dims[2]
+			a := randomTensor(r, m, k)
+			b := randomTensor(r, k, n)
+			bt := b.Transpose().Clone()
+			at := a.Transpose().Clone()
+			want := naiveMatMul(a.ToRows(), b.ToRows())
+
+			cases := map[string]*Tensor{
+				"a·b":     MatMul(a, b),
+				"a·btᵀ":   MatMul(a, bt.Transpose()),
+				"atᵀ·b":   MatMul(at.Transpose(), b),
+				"atᵀ·btᵀ": MatMul(at.Transpose(), bt.Transpose()),
+			}
+			for name, got := range cases {
+				for i := range want {
+					for j, w := range want[i] {
+						if math.Abs(float64(got.At(i, j)-w)) > 1e-4 {
+							t.Fatalf("%d threads, %v, %s: c[%d][%d] = %g, want %g", threads, dims, name, i, j, got.At(i, j), w)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func benchmarkSizes() [][3]int {
+	return [][3]int{{256, 384, 1152}, {256, 384, 1536}, {1, 384, 8192}}
+}
+
+func BenchmarkMatMulNaive(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for _, dims := range benchmarkSizes() {
+		x := randomTensor(r, dims[0], dims[1]).ToRows()
+		w := randomTensor(r, dims[1], dims[2]).ToRows()
+		b.Run(fmt.Sprintf("%dx%dx%d", dims[0], dims[1], dims[2]), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				naiveMatMul(x, w)
+			}
+		})
+	}
+}
+
+func BenchmarkMatMul(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	defer SetNumThreads(0)
+
+	for _, threads := range []int{1, 0} {
+		SetNumThreads(threads)
+		for _, dims := range benchmarkSizes() {
+			x := randomTensor(r, dims[0], dims[1])
+			// Stored [out, in] like Linear.Weight
+			w := randomTensor(r, dims[2], dims[1])
+			b.Run(fmt.Sprintf("threads=%d/%dx%dx%d", NumThreads(), dims[0], dims[1], dims[2]), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					MatMul(x, w.Transpose())
+				}
+			})
+		}
+	}
+}
@@ -1,11 +1,21 @@
 package model
 
+import (
+	"fmt"
+	"iter"
+	"math/rand"
+)
+
 type GPT2 struct {
 	config     Config
 	embeddings *Embeddings
 	layers     []*TransformerLayer
-	finalNorm  *LayerNorm
+	finalNorm  Normalizer
 	lmHead     *LMHead
+
+	// File mapped by the last Load or LoadSafetensors, which the weights
+	// refer to; see Close
+	mapping []byte
 }
 
 type Config struct {
@@ -14,85 +24,378 @@ type Config struct {
 	EmbedDim    int
 	NumHeads    int
 	NumLayers   int
+
+	// NormPosition is NormPost or NormPre; empty means NormPost
+	NormPosition string `json:",omitempty"`
+	// TieEmbeddings makes the LM head reuse the token embedding matrix
+	TieEmbeddings bool `json:",omitempty"`
+	// PositionEncoding is PositionLearned, PositionSinusoidal, PositionRoPE
+	// or PositionALiBi; empty means PositionLearned. Only learned positions
+	// are limited to ContextSize.
+	PositionEncoding string `json:",omitempty"`
+	// NumKVHeads is the number of key and value heads, which must divide
+	// NumHeads; zero means NumHeads. Fewer heads shrink the KV cache.
+	NumKVHeads int `json:",omitempty"`
+	// Activation is the feed-forward activation, one of the Activation
+	// values; empty means ActivationGELUTanh
+	Activation string `json:",omitempty"`
+	// FFNHiddenDim is the hidden size of the feed-forward layers; zero
+	// means 4*EmbedDim
+	FFNHiddenDim int `json:",omitempty"`
+	// NormType is NormLayerNorm or NormRMSNorm; empty means NormLayerNorm
+	NormType string `json:",omitempty"`
+}
+
+// Values of Config.NormPosition
+const (
+	// NormPost normalizes after each residual addition, as in the original
+	// Transformer
+	NormPost = "post"
+	// NormPre normalizes the input of each sublayer, as in GPT-2
+	NormPre = "pre"
+)
+
+// withDefaults returns c with unset optional fields filled in, so configs
+// saved before a field existed compare equal to their explicit form
+func (c Config) withDefaults() Config {
+	if c.NormPosition == "" {
+		c.NormPosition = NormPost
+	}
+	if c.PositionEncoding == "" {
+		c.PositionEncoding = PositionLearned
+	}
+	if c.NumKVHeads == 0 {
+		c.NumKVHeads = c.NumHeads
+	}
+	if c.Activation == "" {
+		c.Activation = ActivationGELUTanh
+	}
+	if c.FFNHiddenDim == 0 {
+		c.FFNHiddenDim = 4 * c.EmbedDim
+	}
+	if c.NormType == "" {
+		c.NormType = NormLayerNorm
+	}
+	return c
 }
 
-func NewGPT2(cfg Config) *GPT2 {
+// Validate checks the values of the settings that are not sizes. Unset
+// optional fields stand for their defaults.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	if c.NormPosition != NormPost && c.NormPosition != NormPre {
+		return fmt.Errorf("unknown norm position %q", c.NormPosition)
+	}
+	if c.NumKVHeads < 0 || (c.NumKVHeads > 0 && c.NumHeads%c.NumKVHeads != 0) {
+		return fmt.Errorf("%d key and value heads do not divide %d heads", c.NumKVHeads, c.NumHeads)
+	}
+	if c.NormType != NormLayerNorm && c.NormType != NormRMSNorm {
+		return fmt.Errorf("unknown norm type %q", c.NormType)
+	}
+	if _, ok := activationFuncs[c.Activation]; !ok && gateActivations[c.Activation] == "" {
+		return fmt.Errorf("unknown activation %q", c.Activation)
+	}
+	if c.FFNHiddenDim < 0 {
+		return fmt.Errorf("invalid feed-forward hidden size %d", c.FFNHiddenDim)
+	}
+	switch c.PositionEncoding {
+	case PositionLearned, PositionSinusoidal, PositionALiBi:
+	case PositionRoPE:
+		if c.NumHeads > 0 && (c.EmbedDim/c.NumHeads)%2 != 0 {
+			return fmt.Errorf("rotary position encoding needs an even head dimension, got %d", c.EmbedDim/c.NumHeads)
+		}
+	default:
+		return fmt.Errorf("unknown position encoding %q", c.PositionEncoding)
+	}
+	return nil
+}
+
+// NewGPT2 creates a model with weights drawn from rng. Construction consumes
+// rng in a fixed order, so the same seed always yields the same weights.
+func NewGPT2(cfg Config, rng *rand.Rand) *GPT2 {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	learnedPositions := 0
+	if cfg.PositionEncoding == PositionLearned {
+		learnedPositions = cfg.ContextSize
+	}
 	g := &GPT2{
 		config:     cfg,
-		embeddings: NewEmbeddings(cfg.VocabSize, cfg.EmbedDim, cfg.ContextSize),
+		embeddings: NewEmbeddings(cfg.VocabSize, cfg.EmbedDim, learnedPositions, rng),
 		layers:     make([]*TransformerLayer, cfg.NumLayers),
-		finalNorm:  NewLayerNorm(cfg.EmbedDim),
-		lmHead:     NewLMHead(cfg.EmbedDim, cfg.VocabSize),
+		finalNorm:  NewNormalizer(cfg.NormType, cfg.EmbedDim),
 	}
 
 	for i := 0; i < cfg.NumLayers; i++ {
-		g.layers[i] = NewTransformerLayer(cfg.EmbedDim, cfg.NumHeads)
+		g.layers[i] = NewTransformerLayer(cfg.EmbedDim, cfg.NumHeads, cfg.NumKVHeads, cfg.FFNHiddenDim, cfg.Activation, cfg.NormType, rng)
+		attention := g.layers[i].Attention
+		attention.Causal = true
+		switch cfg.PositionEncoding {
+		case PositionRoPE:
+			attention.Rotary = NewRotary(attention.HeadDim)
+		case PositionALiBi:
+			attention.ALiBiSlopes = alibiSlopes(attention.NumHeads)
+		}
+	}
+	g.setNormPosition(cfg.NormPosition)
+	g.lmHead = NewLMHead(cfg.EmbedDim, cfg.VocabSize, rng)
+	if cfg.TieEmbeddings {
+		g.lmHead.tie(g.embeddings)
 	}
 
 	return g
 }
 
-func (g *GPT2) Forward(input []int) [][]float32 {
+// setNormPosition switches every block to pre-LN or post-LN. Both layouts
+// have the same parameters.
+func (g *GPT2) setNormPosition(position string) {
+	g.config.NormPosition = position
+	for _, layer := range g.layers {
+		layer.PreNorm = position == NormPre
+	}
+}
+
+// Config returns the configuration the model was built with
+func (g *GPT2) Config() Config {
+	return g.config
+}
+
+// Forward returns the logits of every position of input. With learned
+// position embeddings input must not be longer than ContextSize.
+func (g *GPT2) Forward(input []int) (*Tensor, error) {
+	return g.ForwardMasked(input, nil)
+}
+
+// ForwardMasked is Forward for a padded sequence: positions with
+// mask[i] == false are padding and are never attended to. Their logits are
+// meaningless, so the matching targets should be set to -1, which the loss
+// functions ignore.
+func (g *GPT2) ForwardMasked(input []int, mask []bool) (*Tensor, error) {
+	x, err := g.embed(input, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range g.layers {
+		x = layer.Forward(x, mask)
+	}
+
+	x = g.finalNorm.Apply(x)
+
+	return g.lmHead.Forward(x), nil
+}
+
+// ForwardCached returns the logits of tokens, which continue the sequence
+// already held in cache, and extends the cache with them. Feeding a prompt
+// and then one token at a time yields the same logits as Forward over the
+// whole sequence while only processing each token once. With learned
+// position embeddings it fails, leaving the cache untouched, when the cache
+// would grow beyond ContextSize positions.
+func (g *GPT2) ForwardCached(tokens []int, cache *KVCache) (*Tensor, error) {
+	offset := cache.Len()
+	if g.config.PositionEncoding == PositionLearned && offset+len(tokens) > g.config.ContextSize {
+		return nil, fmt.Errorf("KV cache overflow: %d cached + %d new positions exceed context size %d",
+			offset, len(tokens), g.config.ContextSize)
+	}
+
+	x, err := g.embed(tokens, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, layer := range g.layers {
+		x = layer.ForwardCached(x, cache.Layers[i])
+	}
+
+	x = g.finalNorm.Apply(x)
+
+	return g.lmHead.Forward(x), nil
+}
+
+// embed sums the token embeddings of input with the encodings of positions
+// offset, offset+1, ... RoPE and ALiBi encode positions in the attention
+// instead, so they add nothing here. Learned positions fail beyond
+// ContextSize.
+func (g *GPT2) embed(input []int, offset int) (*Tensor, error) {
 	embeddings := g.embeddings.Lookup(input)
 
 	positions := make([]int, len(input))
 	for i := range positions {
-		positions[i] = i
+		positions[i] = offset + i
 	}
-	posEmbed := g.embeddings.PositionLookup(positions)
-
-	x := make([][]float32, len(embeddings))
-	for i := range embeddings {
-		x[i] = make([]float32, len(embeddings[i]))
-		for j := range embeddings[i] {
-			x[i][j] = embeddings[i][j] + posEmbed[i][j]
+	switch g.config.PositionEncoding {
+	case PositionLearned:
+		learned, err := g.embeddings.PositionLookup(positions)
+		if err != nil {
+			return nil, fmt.Errorf("sequence of %d tokens exceeds context size %d", offset+len(input), g.config.ContextSize)
 		}
+		return addTensors(embeddings, learned), nil
+	case PositionSinusoidal:
+		return addTensors(embeddings, sinusoidalEncoding(positions, g.config.EmbedDim)), nil
+	}
+	return embeddings, nil
+}
+
+// Loss returns the cross-entropy loss of predicting targets from input, with
+// the same length limit as Forward
+func (g *GPT2) Loss(input []int, targets []int) (float32, error) {
+	logits, err := g.Forward(input)
+	if err != nil {
+		return 0, err
 	}
+	return CrossEntropyLoss(logits, targets), nil
+}
 
-	for _, layer := range g.layers {
-		x = layer.Forward(x)
+// Backward propagates dLogits, the gradient of the loss with respect to the
+// logits returned by the last Forward call, through the whole network and
+// accumulates the gradient of every parameter. Gradients add up across calls
+// until ZeroGrad is called, so several sequences can form one batch.
+func (g *GPT2) Backward(dLogits *Tensor) {
+	dx := g.lmHead.Backward(dLogits)
+	dx = g.finalNorm.Backward(dx)
+
+	for i := len(g.layers) - 1; i >= 0; i-- {
+		dx = g.layers[i].Backward(dx)
 	}
 
-	x = g.finalNorm.Apply(x)
+	g.embeddings.Backward(dx)
+}
 
-	return g.lmHead.Forward(x)
+// Parameters returns every trainable tensor of the model with its gradient
+func (g *GPT2) Parameters() []*Parameter {
+	params := g.embeddings.parameters()
+	for i, layer := range g.layers {
+		params = append(params, layer.parameters(i)...)
+	}
+	params = append(params, g.finalNorm.parameters("final_norm")...)
+	return append(params, g.lmHead.parameters()...)
 }
 
-func (g *GPT2) Loss(input []int, targets []int) float32 {
-	logits := g.Forward(input)
-	return crossEntropyLoss(logits, targets)
+// ZeroGrad clears the gradients accumulated by Backward
+func (g *GPT2) ZeroGrad() {
+	for _, p := range g.Parameters() {
+		p.ZeroGrad()
+	}
 }
 
-func crossEntropyLoss(logits [][]float32, targets []int) float32 {
-	return 0.0
+// KVCache holds the attention keys and values of every layer for the tokens
+// processed so far by ForwardCached. A cache built for a common prompt can be
+// cloned to continue several generations from it. With learned position
+// embeddings a cache holds at most ContextSize positions; callers feeding
+// longer sequences have to Truncate or Reset it, as GenerateStream does.
+type KVCache struct {
+	Layers []*LayerKVCache
 }
 
-// Generate generates text given a prompt
-func (g *GPT2) Generate(prompt []int, maxTokens int, temperature float32) []int {
-	if temperature <= 0 {
-		temperature = 0.7
+// NewKVCache returns an empty cache sized for the model's layers
+func (g *GPT2) NewKVCache() *KVCache {
+	c := &KVCache{Layers: make([]*LayerKVCache, len(g.layers))}
+	for i := range c.Layers {
+		c.Layers[i] = &LayerKVCache{}
 	}
+	return c
+}
 
-	tokens := make([]int, len(prompt))
+// Len returns the number of cached positions
+func (c *KVCache) Len() int {
+	if len(c.Layers) == 0 {
+		return 0
+	}
+	return c.Layers[0].Len()
+}
+
+// Reset empties the cache
+func (c *KVCache) Reset() {
+	c.Truncate(0)
+}
+
+// Truncate keeps only the first n positions, e.g. to roll back to a prefix
+func (c *KVCache) Truncate(n int) {
+	for _, l := range c.Layers {
+		l.Truncate(n)
+	}
+}
+
+// Clone returns a cache that can be extended independently of c
+func (c *KVCache) Clone() *KVCache {
+	clone := &KVCache{Layers: make([]*LayerKVCache, len(c.Layers))}
+	for i, l := range c.Layers {
+		clone.Layers[i] = l.Clone()
+	}
+	return clone
+}
+
+// Generate generates text given a prompt, picking each token according to
+// params with randomness drawn from rng. It returns the prompt followed by
+// the generated tokens, maxTokens in all unless a stop token ends it early.
+func (g *GPT2) Generate(prompt []int, maxTokens int, params SamplingParams, rng *rand.Rand) []int {
+	tokens := make([]int, len(prompt), max(len(prompt), maxTokens))
 	copy(tokens, prompt)
+	for token := range g.GenerateStream(prompt, maxTokens, params, rng) {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
 
-	for len(tokens) < maxTokens {
-		context := tokens
-		if len(tokens) > g.config.ContextSize {
-			context = tokens[len(tokens)-g.config.ContextSize:]
-		}
+// GenerateStream is Generate yielding each new token as soon as it is
+// sampled; the prompt is not yielded. Breaking out of the loop stops
+// generation. Tokens are decoded incrementally through a KV cache, see
+// slideWindow for how sequences longer than ContextSize are handled.
+func (g *GPT2) GenerateStream(prompt []int, maxTokens int, params SamplingParams, rng *rand.Rand) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		tokens := make([]int, len(prompt))
+		copy(tokens, prompt)
 
-		probs := g.Forward(context)
-		nextTokenProbs := probs[len(probs)-1]
+		cache := g.NewKVCache()
+		pending := tokens
 
-		nextToken := g.lmHead.Sample(nextTokenProbs, temperature)
+		for len(tokens) < maxTokens {
+			pending = g.slideWindow(tokens, pending, cache)
+			logits, err := g.ForwardCached(pending, cache)
+			if err != nil {
+				// Unreachable: slideWindow keeps the cache of learned
+				// positions within ContextSize
+				panic(err.Error())
+			}
+			history := tokens[len(prompt):]
+			if params.PenalizePrompt {
+				history = tokens
+			}
+			nextTokenLogits := params.penalize(logits.Row(logits.Rows()-1), history)
 
-		if nextToken == 0 {
-			break
-		}
+			nextToken := g.lmHead.Sample(nextTokenLogits, params, rng)
+
+			if params.isStop(nextToken) {
+				return
+			}
 
-		tokens = append(tokens, nextToken)
+			tokens = append(tokens, nextToken)
+			pending = tokens[len(tokens)-1:]
+			if !yield(nextToken) {
+				return
+			}
+		}
 	}
+}
 
-	return tokens
+// slideWindow returns the tokens to feed through cache next, given pending,
+// the tokens at the end of tokens that are not cached yet. Learned position
+// embeddings end at ContextSize: a prompt is cut to its last ContextSize
+// tokens, and once the window is full the cache is emptied and rebuilt from
+// the most recent half of it. The other position encodings keep the whole
+// sequence.
+func (g *GPT2) slideWindow(tokens, pending []int, cache *KVCache) []int {
+	size := g.config.ContextSize
+	if g.config.PositionEncoding != PositionLearned || cache.Len()+len(pending) <= size {
+		return pending
+	}
+	keep := size
+	if cache.Len() > 0 {
+		keep = max(size/2, 1)
+	}
+	cache.Reset()
+	return tokens[len(tokens)-keep:]
 }
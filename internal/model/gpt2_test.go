@@ -0,0 +1,439 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func testRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+func tinyConfig() Config {
+	return Config{
+		VocabSize:   11,
+		ContextSize: 8,
+		EmbedDim:    8,
+		NumHeads:    2,
+		NumLayers:   2,
+	}
+}
+
+func TestGPT2_BackwardMatchesFiniteDifferences(t *testing.T) {
+	preNorm := tinyConfig()
+	preNorm.NormPosition = NormPre
+	tied := tinyConfig()
+	tied.TieEmbeddings = true
+
+	configs := map[string]Config{"post-norm": tinyConfig(), "pre-norm": preNorm, "tied": tied}
+	for _, encoding := range []string{PositionSinusoidal, PositionRoPE, PositionALiBi} {
+		configs[encoding] = positionConfig(encoding)
+	}
+	configs["multi-query"] = kvHeadsConfig(1)
+	gqa := kvHeadsConfig(2)
+	gqa.PositionEncoding = PositionRoPE
+	configs["grouped-query rope"] = gqa
+	for _, activation := range []string{ActivationGELU, ActivationReLU, ActivationSiLU, ActivationSwiGLU, ActivationGeGLU, ActivationReGLU} {
+		cfg := tinyConfig()
+		cfg.Activation = activation
+		cfg.FFNHiddenDim = 12
+		configs[activation] = cfg
+	}
+	rms := tinyConfig()
+	rms.NormType = NormRMSNorm
+	configs["rmsnorm"] = rms
+	configs["llama-style"] = llamaConfig()
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			checkGradients(t, NewGPT2(cfg, testRand()))
+		})
+	}
+}
+
+// llamaConfig returns a config combining pre-norm RMSNorm blocks, rotary
+// positions, SwiGLU and grouped-query attention like LLaMA
+func llamaConfig() Config {
+	cfg := kvHeadsConfig(2)
+	cfg.NormPosition = NormPre
+	cfg.NormType = NormRMSNorm
+	cfg.PositionEncoding = PositionRoPE
+	cfg.Activation = ActivationSwiGLU
+	cfg.FFNHiddenDim = 12
+	return cfg
+}
+
+// kvHeadsConfig returns a config with four query heads sharing numKVHeads key
+// and value heads
+func kvHeadsConfig(numKVHeads int) Config {
+	cfg := tinyConfig()
+	cfg.NumHeads = 4
+	cfg.NumKVHeads = numKVHeads
+	return cfg
+}
+
+func positionConfig(encoding string) Config {
+	cfg := tinyConfig()
+	cfg.PositionEncoding = encoding
+	return cfg
+}
+
+// checkGradients compares the gradients of Backward with finite differences
+// of the loss
+func checkGradients(t *testing.T, g *GPT2) {
+	input := []int{1, 4, 2, 7, 3}
+	targets := []int{4, 2, 7, 3, 9}
+
+	g.ZeroGrad()
+	_, dLogits := CrossEntropyLossGrad(mustForward(t, g, input), targets)
+	g.Backward(dLogits)
+
+	const eps = 1e-3
+	for _, p := range g.Parameters() {
+		// Probe a few entries of every tensor, including rows touched by the input
+		for _, i := range []int{0, p.Size()/2 + 1, p.Size() - 1} {
+			orig := p.Value.Data[i]
+
+			p.Value.Data[i] = orig + eps
+			lossPlus := mustLoss(t, g, input, targets)
+			p.Value.Data[i] = orig - eps
+			lossMinus := mustLoss(t, g, input, targets)
+			p.Value.Data[i] = orig
+
+			numeric := (lossPlus - lossMinus) / (2 * eps)
+			analytic := p.Grad.Data[i]
+			diff := math.Abs(float64(numeric - analytic))
+			if diff > 1e-3+0.05*math.Abs(float64(numeric)) {
+				t.Errorf("%s[%d]: analytic gradient %g, numeric %g", p.Name, i, analytic, numeric)
+			}
+		}
+	}
+}
+
+func TestGPT2_TrainingLowersLoss(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	input := []int{1, 2, 3, 4, 5, 6}
+	targets := []int{2, 3, 4, 5, 6, 7}
+
+	initial := mustLoss(t, g, input, targets)
+	for step := 0; step < 50; step++ {
+		g.ZeroGrad()
+		_, dLogits := CrossEntropyLossGrad(mustForward(t, g, input), targets)
+		g.Backward(dLogits)
+
+		for _, p := range g.Parameters() {
+			for i := range p.Value.Data {
+				p.Value.Data[i] -= 0.1 * p.Grad.Data[i]
+			}
+		}
+	}
+
+	if final := mustLoss(t, g, input, targets); final >= initial*0.5 {
+		t.Errorf("loss did not decrease enough: initial %.4f, final %.4f", initial, final)
+	}
+}
+
+func TestGPT2_ForwardIsCausal(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+
+	a := mustForward(t, g, []int{1, 2, 3, 4})
+	b := mustForward(t, g, []int{1, 2, 3, 9})
+
+	for i := 0; i < 3; i++ {
+		for j := range a.Row(i) {
+			if a.Row(i)[j] != b.Row(i)[j] {
+				t.Fatalf("logits at position %d depend on a future token", i)
+			}
+		}
+	}
+}
+
+func assertLogitsClose(t *testing.T, got, want []float32, msg string) {
+	t.Helper()
+	for j := range want {
+		if diff := math.Abs(float64(got[j] - want[j])); diff > 1e-4 {
+			t.Fatalf("%s: logit %d = %g, want %g", msg, j, got[j], want[j])
+		}
+	}
+}
+
+// mustForward is Forward failing the test on error
+func mustForward(t *testing.T, g *GPT2, input []int) *Tensor {
+	t.Helper()
+	logits, err := g.Forward(input)
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	return logits
+}
+
+// mustLoss is Loss failing the test on error
+func mustLoss(t *testing.T, g *GPT2, input, targets []int) float32 {
+	t.Helper()
+	loss, err := g.Loss(input, targets)
+	if err != nil {
+		t.Fatalf("Loss() error = %v", err)
+	}
+	return loss
+}
+
+// mustForwardCached is ForwardCached failing the test on error
+func mustForwardCached(t *testing.T, g *GPT2, tokens []int, cache *KVCache) *Tensor {
+	t.Helper()
+	logits, err := g.ForwardCached(tokens, cache)
+	if err != nil {
+		t.Fatalf("ForwardCached() error = %v", err)
+	}
+	return logits
+}
+
+func TestGPT2_ForwardCachedMatchesForward(t *testing.T) {
+	for _, encoding := range []string{PositionLearned, PositionSinusoidal, PositionRoPE, PositionALiBi} {
+		t.Run(encoding, func(t *testing.T) {
+			checkForwardCached(t, NewGPT2(positionConfig(encoding), testRand()), []int{3, 1, 4, 1, 5, 9})
+		})
+	}
+	t.Run("grouped-query", func(t *testing.T) {
+		g := NewGPT2(kvHeadsConfig(2), testRand())
+		checkForwardCached(t, g, []int{3, 1, 4, 1, 5, 9})
+
+		cache := g.NewKVCache()
+		mustForwardCached(t, g, []int{3, 1}, cache)
+		// 2 positions of 2 key heads of 2 dimensions
+		if got, want := len(cache.Layers[0].Keys), 2*2*2; got != want {
+			t.Errorf("cached %d key values for 2 positions, want %d", got, want)
+		}
+	})
+}
+
+// checkForwardCached compares the logits of a prompt fed through the cache
+// in one piece and then token by token with those of Forward
+func checkForwardCached(t *testing.T, g *GPT2, input []int) {
+	t.Helper()
+	full := mustForward(t, g, input)
+
+	cache := g.NewKVCache()
+	prompt := mustForwardCached(t, g, input[:3], cache)
+	for i := 0; i < prompt.Rows(); i++ {
+		assertLogitsClose(t, prompt.Row(i), full.Row(i), "prompt")
+	}
+	for i := 3; i < len(input); i++ {
+		step := mustForwardCached(t, g, input[i:i+1], cache)
+		assertLogitsClose(t, step.Row(0), full.Row(i), "incremental step")
+	}
+	if cache.Len() != len(input) {
+		t.Errorf("cache.Len() = %d, want %d", cache.Len(), len(input))
+	}
+}
+
+func TestGPT2_ForwardCachedOverflow(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	cache := g.NewKVCache()
+	mustForwardCached(t, g, []int{1, 2, 3, 4, 5, 6}, cache)
+	if _, err := g.ForwardCached([]int{7, 8, 9}, cache); err == nil {
+		t.Fatal("ForwardCached() past the context size returned no error")
+	}
+	if cache.Len() != 6 {
+		t.Errorf("failed ForwardCached() left %d cached positions, want 6", cache.Len())
+	}
+	mustForwardCached(t, g, []int{7, 8}, cache)
+}
+
+func TestGPT2_RelativePositionsExtendPastContext(t *testing.T) {
+	// Twice the context size of tinyConfig
+	input := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3}
+	for _, encoding := range []string{PositionSinusoidal, PositionRoPE, PositionALiBi} {
+		t.Run(encoding, func(t *testing.T) {
+			checkForwardCached(t, NewGPT2(positionConfig(encoding), testRand()), input)
+		})
+	}
+
+	g := NewGPT2(tinyConfig(), testRand())
+	if _, err := g.Forward(input); err == nil {
+		t.Errorf("Forward() past the learned positions returned no error")
+	}
+	if _, err := g.Loss(input, input); err == nil {
+		t.Errorf("Loss() past the learned positions returned no error")
+	}
+}
+
+func TestKVCache_CloneAndTruncate(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	prefix := g.NewKVCache()
+	mustForwardCached(t, g, []int{2, 7}, prefix)
+
+	a := prefix.Clone()
+	b := prefix.Clone()
+	gotA := mustForwardCached(t, g, []int{1}, a)
+	gotB := mustForwardCached(t, g, []int{5}, b)
+
+	assertLogitsClose(t, gotA.Row(0), mustForward(t, g, []int{2, 7, 1}).Row(2), "branch a")
+	assertLogitsClose(t, gotB.Row(0), mustForward(t, g, []int{2, 7, 5}).Row(2), "branch b")
+	if prefix.Len() != 2 {
+		t.Errorf("extending a clone changed the shared prefix to %d positions", prefix.Len())
+	}
+
+	a.Truncate(2)
+	again := mustForwardCached(t, g, []int{5}, a)
+	assertLogitsClose(t, again.Row(0), gotB.Row(0), "after truncate")
+}
+
+func TestGPT2_GeneratePenalizesOnlyGeneratedTokens(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	// Token 4 is picked greedily
+	g.lmHead.linear.Bias.Data[4] = 100
+	params := SamplingParams{PresencePenalty: 1000}
+
+	tokens := g.Generate([]int{4, 4}, 4, params, testRand())
+	if tokens[2] != 4 || tokens[3] == 4 {
+		t.Errorf("Generate() = %v, want 4 once after the prompt", tokens)
+	}
+
+	params.PenalizePrompt = true
+	tokens = g.Generate([]int{4, 4}, 3, params, testRand())
+	if tokens[2] == 4 {
+		t.Errorf("Generate() = %v repeats the prompt token with PenalizePrompt", tokens)
+	}
+}
+
+func TestGPT2_GenerateBeyondContext(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+
+	tokens := g.Generate([]int{1, 2, 3}, 20, SamplingParams{Temperature: 1}, testRand())
+	if len(tokens) != 20 {
+		t.Errorf("generated %d tokens, want 20", len(tokens))
+	}
+}
+
+func TestGPT2_GenerateRelativePositionsPastContext(t *testing.T) {
+	for _, encoding := range []string{PositionRoPE, PositionALiBi} {
+		t.Run(encoding, func(t *testing.T) {
+			g := NewGPT2(positionConfig(encoding), testRand())
+
+			// Greedy decoding up to three times the context size of 8, the
+			// way GenerateStream does it
+			tokens := []int{1, 2, 3}
+			cache := g.NewKVCache()
+			pending := tokens
+			for len(tokens) < 24 {
+				pending = g.slideWindow(tokens, pending, cache)
+				logits := mustForwardCached(t, g, pending, cache)
+				if cache.Len() != len(tokens) {
+					t.Fatalf("cache holds %d positions of %d tokens, want all of them", cache.Len(), len(tokens))
+				}
+				tokens = append(tokens, argmax(logits.Row(logits.Rows()-1)))
+				pending = tokens[len(tokens)-1:]
+			}
+
+			if got := g.Generate([]int{1, 2, 3}, 24, SamplingParams{}, testRand()); !reflect.DeepEqual(got, tokens) {
+				t.Errorf("Generate() = %v, want %v", got, tokens)
+			}
+		})
+	}
+}
+
+func TestGPT2_SlideWindowLearnedPositions(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	tokens := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	cache := g.NewKVCache()
+	pending := g.slideWindow(tokens, tokens, cache)
+	if !reflect.DeepEqual(pending, tokens[2:]) {
+		t.Fatalf("prompt window = %v, want the last 8 tokens", pending)
+	}
+	mustForwardCached(t, g, pending, cache)
+
+	pending = g.slideWindow(tokens, tokens[9:], cache)
+	if !reflect.DeepEqual(pending, tokens[6:]) || cache.Len() != 0 {
+		t.Errorf("full window = %v with %d cached, want the last 4 tokens and an empty cache", pending, cache.Len())
+	}
+}
+
+func TestGPT2_GenerateStopsAtStopToken(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	// Token 0 is sampled every time, and with it out of the way token 4
+	g.lmHead.linear.Bias.Data[0] = 100
+
+	tokens := g.Generate([]int{1, 2}, 10, SamplingParams{}, testRand())
+	if len(tokens) != 10 {
+		t.Errorf("token 0 stopped generation without being a stop token: %v", tokens)
+	}
+
+	g.lmHead.linear.Bias.Data[0] = 0
+	g.lmHead.linear.Bias.Data[4] = 100
+	tokens = g.Generate([]int{1, 2}, 10, SamplingParams{StopTokens: []int{7, 4}}, testRand())
+	if want := []int{1, 2}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("Generate() = %v, want %v", tokens, want)
+	}
+}
+
+func TestGPT2_SameSeedIsReproducible(t *testing.T) {
+	a := NewGPT2(tinyConfig(), rand.New(rand.NewSource(42)))
+	b := NewGPT2(tinyConfig(), rand.New(rand.NewSource(42)))
+
+	pa, pb := a.Parameters(), b.Parameters()
+	for i := range pa {
+		va, vb := pa[i].Value.Values(), pb[i].Value.Values()
+		for j := range va {
+			if math.Float32bits(va[j]) != math.Float32bits(vb[j]) {
+				t.Fatalf("%s[%d] differs between models built from the same seed", pa[i].Name, j)
+			}
+		}
+	}
+
+	params := SamplingParams{Temperature: 1}
+	outA := a.Generate([]int{1, 2}, 12, params, rand.New(rand.NewSource(7)))
+	outB := b.Generate([]int{1, 2}, 12, params, rand.New(rand.NewSource(7)))
+	if len(outA) != len(outB) {
+		t.Fatalf("Generate() lengths differ: %v vs %v", outA, outB)
+	}
+	for i := range outA {
+		if outA[i] != outB[i] {
+			t.Fatalf("Generate() differs for the same seed: %v vs %v", outA, outB)
+		}
+	}
+
+	c := NewGPT2(tinyConfig(), rand.New(rand.NewSource(43)))
+	if c.Parameters()[0].Value.Data[0] == pa[0].Value.Data[0] {
+		t.Errorf("different seeds produced the same weights")
+	}
+}
+
+func TestGPT2_GenerateStreamMatchesGenerate(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	params := SamplingParams{Temperature: 1}
+	prompt := []int{1, 2, 3}
+
+	want := g.Generate(prompt, 15, params, rand.New(rand.NewSource(3)))
+	got := append([]int(nil), prompt...)
+	for token := range g.GenerateStream(prompt, 15, params, rand.New(rand.NewSource(3))) {
+		got = append(got, token)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GenerateStream() = %v, want %v", got, want)
+	}
+
+	// Breaking out of the loop stops generation
+	n := 0
+	for range g.GenerateStream(prompt, 15, params, testRand()) {
+		n++
+		if n == 4 {
+			break
+		}
+	}
+	if n != 4 {
+		t.Errorf("received %d tokens, want 4", n)
+	}
+}
+
+func TestConfig_ValidateDefaults(t *testing.T) {
+	if err := tinyConfig().Validate(); err != nil {
+		t.Errorf("Validate() with unset optional fields = %v, want nil", err)
+	}
+	cfg := tinyConfig()
+	cfg.NormPosition = "middle"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Validate() accepted norm position %q", cfg.NormPosition)
+	}
+}
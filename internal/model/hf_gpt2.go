@@ -0,0 +1,200 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// hfGPT2Config holds the fields of a HuggingFace GPT-2 config.json that
+// matter to GPT2
+type hfGPT2Config struct {
+	VocabSize          int      `json:"vocab_size"`
+	NPositions         int      `json:"n_positions"`
+	NCtx               int      `json:"n_ctx"` // older name of n_positions
+	NEmbd              int      `json:"n_embd"`
+	NHead              int      `json:"n_head"`
+	NLayer             int      `json:"n_layer"`
+	NInner             *int     `json:"n_inner"` // null means 4*n_embd
+	LayerNormEpsilon   *float64 `json:"layer_norm_epsilon"`
+	ActivationFunction string   `json:"activation_function"`
+	TieWordEmbeddings  *bool    `json:"tie_word_embeddings"`
+}
+
+// ImportHFGPT2 builds a GPT2 from a HuggingFace GPT-2 checkpoint directory
+// holding config.json and model.safetensors. The HF tensors map onto the
+// model as follows, with N the layer index:
+//
+//	wte, wpe                  token_embeddings, position_embeddings
+//	h.N.ln_1, h.N.ln_2        layers.N.norm1, layers.N.norm2
+//	h.N.attn.c_attn           layers.N.qkv_proj
+//	h.N.attn.c_proj           layers.N.out_proj
+//	h.N.mlp.c_fc              layers.N.ff1
+//	h.N.mlp.c_proj            layers.N.ff2
+//	ln_f                      final_norm
+//	lm_head                   lm_head, unless tied to wte
+//
+// HF stores these projections as Conv1D weights of shape [in, out], which
+// are transposed into the [out, in] layout of Linear. Tensor names may carry
+// a "transformer." prefix. The model uses pre-LN blocks like GPT-2.
+func ImportHFGPT2(dir string) (*GPT2, error) {
+	hfCfg, err := readHFGPT2Config(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	tensors, _, mapping, err := readSafetensors(filepath.Join(dir, "model.safetensors"))
+	if err != nil {
+		return nil, err
+	}
+	// The weights are copied out of the file
+	defer unmapFile(mapping)
+	for name, t := range maps.Clone(tensors) {
+		if trimmed, ok := strings.CutPrefix(name, "transformer."); ok {
+			tensors[trimmed] = t
+		}
+	}
+
+	tied := hfCfg.TieWordEmbeddings == nil || *hfCfg.TieWordEmbeddings
+	if _, ok := tensors["lm_head.weight"]; !ok {
+		tied = true
+	}
+
+	ffnHiddenDim := 0
+	if hfCfg.NInner != nil {
+		ffnHiddenDim = *hfCfg.NInner
+	}
+
+	// Every weight is overwritten below and the LM head has no bias in GPT-2,
+	// which matches the zero bias of a new Linear, so the seed does not matter
+	g := NewGPT2(Config{
+		VocabSize:     hfCfg.VocabSize,
+		ContextSize:   hfCfg.NPositions,
+		EmbedDim:      hfCfg.NEmbd,
+		NumHeads:      hfCfg.NHead,
+		NumLayers:     hfCfg.NLayer,
+		NormPosition:  NormPre,
+		TieEmbeddings: tied,
+		Activation:    hfActivations[hfCfg.ActivationFunction],
+		FFNHiddenDim:  ffnHiddenDim,
+	}, rand.New(rand.NewSource(0)))
+
+	targets := []hfTarget{
+		{"wte.weight", g.embeddings.TokenEmbed, false},
+		{"wpe.weight", g.embeddings.PositionEmbed, false},
+	}
+	for i, layer := range g.layers {
+		prefix := fmt.Sprintf("h.%d.", i)
+		norm1, norm2 := layer.Norm1.(*LayerNorm), layer.Norm2.(*LayerNorm)
+		targets = append(targets, []hfTarget{
+			{prefix + "ln_1.weight", norm1.Gamma, false},
+			{prefix + "ln_1.bias", norm1.Beta, false},
+			{prefix + "attn.c_attn.weight", layer.Attention.QKVProj.Weight, true},
+			{prefix + "attn.c_attn.bias", layer.Attention.QKVProj.Bias, false},
+			{prefix + "attn.c_proj.weight", layer.Attention.OutProj.Weight, true},
+			{prefix + "attn.c_proj.bias", layer.Attention.OutProj.Bias, false},
+			{prefix + "ln_2.weight", norm2.Gamma, false},
+			{prefix + "ln_2.bias", norm2.Beta, false},
+			{prefix + "mlp.c_fc.weight", layer.FFN.fc1.Weight, true},
+			{prefix + "mlp.c_fc.bias", layer.FFN.fc1.Bias, false},
+			{prefix + "mlp.c_proj.weight", layer.FFN.fc2.Weight, true},
+			{prefix + "mlp.c_proj.bias", layer.FFN.fc2.Bias, false},
+		}...)
+	}
+	targets = append(targets,
+		hfTarget{"ln_f.weight", g.finalNorm.(*LayerNorm).Gamma, false},
+		hfTarget{"ln_f.bias", g.finalNorm.(*LayerNorm).Beta, false},
+	)
+	if !tied {
+		targets = append(targets, hfTarget{"lm_head.weight", g.lmHead.linear.Weight, false})
+	}
+
+	for _, target := range targets {
+		t, ok := tensors[target.name]
+		if !ok {
+			return nil, fmt.Errorf("failed to import %s: missing from checkpoint", target.name)
+		}
+		if err := target.load(t); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %v", target.name, err)
+		}
+	}
+
+	return g, nil
+}
+
+// hfActivations maps the activation_function values of GPT-2 configs to
+// Activation values
+var hfActivations = map[string]string{
+	"":                  ActivationGELUTanh,
+	"gelu_new":          ActivationGELUTanh,
+	"gelu_pytorch_tanh": ActivationGELUTanh,
+	"gelu":              ActivationGELU,
+	"relu":              ActivationReLU,
+	"silu":              ActivationSiLU,
+	"swish":             ActivationSiLU,
+}
+
+// readHFGPT2Config reads config.json and rejects settings GPT2 cannot
+// reproduce
+func readHFGPT2Config(path string) (*hfGPT2Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	var cfg hfGPT2Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if cfg.NPositions == 0 {
+		cfg.NPositions = cfg.NCtx
+	}
+	if cfg.VocabSize <= 0 || cfg.NPositions <= 0 || cfg.NEmbd <= 0 || cfg.NHead <= 0 || cfg.NLayer <= 0 {
+		return nil, fmt.Errorf("config is missing model dimensions")
+	}
+	if cfg.NEmbd%cfg.NHead != 0 {
+		return nil, fmt.Errorf("n_embd (%d) is not divisible by n_head (%d)", cfg.NEmbd, cfg.NHead)
+	}
+	if _, ok := hfActivations[cfg.ActivationFunction]; !ok {
+		return nil, fmt.Errorf("unsupported activation function %q", cfg.ActivationFunction)
+	}
+	if cfg.LayerNormEpsilon != nil && math.Abs(*cfg.LayerNormEpsilon-1e-5) > 1e-12 {
+		return nil, fmt.Errorf("unsupported layer norm epsilon %g", *cfg.LayerNormEpsilon)
+	}
+	return &cfg, nil
+}
+
+// hfTarget pairs an HF tensor name with the model tensor it is copied into
+type hfTarget struct {
+	name      string
+	dst       *Tensor
+	transpose bool // stored as a Conv1D weight of shape [in, out]
+}
+
+// load copies t into the target, transposing Conv1D weights
+func (target hfTarget) load(t rawTensor) error {
+	shape := target.dst.Shape
+	if target.transpose {
+		shape = []int{shape[1], shape[0]}
+	}
+	if !slices.Equal(t.Shape, shape) {
+		return fmt.Errorf("expected shape %v, got %v", shape, t.Shape)
+	}
+	values, err := t.values()
+	if err != nil {
+		return err
+	}
+
+	src := TensorFrom(values, shape...)
+	if target.transpose {
+		src = src.Transpose().Clone()
+	}
+	copy(target.dst.Values(), src.Values())
+	return nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixture and its reference logits are generated by
+// testdata/gpt2-tiny/make_fixture.py.
+const hfFixtureDir = "testdata/gpt2-tiny"
+
+func TestImportHFGPT2_MatchesReferenceLogits(t *testing.T) {
+	g, err := ImportHFGPT2(hfFixtureDir)
+	if err != nil {
+		t.Fatalf("ImportHFGPT2() error = %v", err)
+	}
+	if !g.config.TieEmbeddings || g.config.NormPosition != NormPre {
+		t.Errorf("config = %+v, want tied embeddings and pre-LN", g.config)
+	}
+
+	data, err := os.ReadFile(filepath.Join(hfFixtureDir, "reference_logits.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ref struct {
+		Input  []int       `json:"input"`
+		Logits [][]float32 `json:"logits"`
+	}
+	if err := json.Unmarshal(data, &ref); err != nil {
+		t.Fatal(err)
+	}
+
+	logits := mustForward(t, g, ref.Input)
+	for i, want := range ref.Logits {
+		assertLogitsClose(t, logits.Row(i), want, "Forward")
+	}
+
+	cache := g.NewKVCache()
+	for i, tok := range ref.Input {
+		step := mustForwardCached(t, g, []int{tok}, cache)
+		assertLogitsClose(t, step.Row(0), ref.Logits[i], "ForwardCached")
+	}
+}
+
+func TestImportHFGPT2_TiedWeightsSurviveSaveLoad(t *testing.T) {
+	g, err := ImportHFGPT2(hfFixtureDir)
+	if err != nil {
+		t.Fatalf("ImportHFGPT2() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "gpt2.pt")
+	if err := g.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	loaded := NewGPT2(cfg, testRand())
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+
+	if loaded.lmHead.linear.Weight != loaded.embeddings.TokenEmbed {
+		t.Errorf("LM head is not tied to the token embeddings after Load")
+	}
+	input := []int{1, 2, 3}
+	want, got := mustForward(t, g, input), mustForward(t, loaded, input)
+	for i := range input {
+		assertLogitsClose(t, got.Row(i), want.Row(i), "loaded Forward")
+	}
+}
@@ -1,30 +1,72 @@
 package model
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
+
+// Values of Config.NormType
+const (
+	NormLayerNorm = "layernorm"
+	NormRMSNorm   = "rmsnorm"
+)
+
+// Normalizer normalizes each row of its input, like LayerNorm and RMSNorm
+type Normalizer interface {
+	Apply(x *Tensor) *Tensor
+	// Backward accumulates the parameter gradients for the last Apply call
+	// and returns the gradient with respect to its input
+	Backward(dOut *Tensor) *Tensor
+
+	parameters(prefix string) []*Parameter
+}
+
+// NewNormalizer returns a normalizer of dim features of the given NormType
+func NewNormalizer(normType string, dim int) Normalizer {
+	switch normType {
+	case NormLayerNorm:
+		return NewLayerNorm(dim)
+	case NormRMSNorm:
+		return NewRMSNorm(dim)
+	}
+	panic(fmt.Sprintf("unknown norm type %q", normType))
+}
 
 type LayerNorm struct {
-	Gamma []float32
-	Beta  []float32
+	Gamma *Tensor
+	Beta  *Tensor
 	Eps   float32
+
+	// Gradients accumulated by Backward, allocated on first use
+	GammaGrad *Tensor
+	BetaGrad  *Tensor
+
+	// Normalized inputs and inverse standard deviations of the last Apply
+	normalized *Tensor
+	invStdDev  []float32
 }
 
 func NewLayerNorm(dim int) *LayerNorm {
 	ln := &LayerNorm{
-		Gamma: make([]float32, dim),
-		Beta:  make([]float32, dim),
+		Gamma: NewTensor(dim),
+		Beta:  NewTensor(dim),
 		Eps:   1e-5,
 	}
 
-	for i := range ln.Gamma {
-		ln.Gamma[i] = 1
+	for i := range ln.Gamma.Data {
+		ln.Gamma.Data[i] = 1
 	}
 	return ln
 }
 
-func (ln *LayerNorm) Apply(x [][]float32) [][]float32 {
-	output := make([][]float32, len(x))
+func (ln *LayerNorm) Apply(x *Tensor) *Tensor {
+	output := NewTensor(x.Shape...)
+	ln.normalized = NewTensor(x.Shape...)
+	ln.invStdDev = make([]float32, x.Rows())
+	gamma, beta := ln.Gamma.Data, ln.Beta.Data
 
-	for i, vec := range x {
+	for i := 0; i < x.Rows(); i++ {
+		vec := x.Row(i)
 		var mean float32
 		for _, v := range vec {
 			mean += v
@@ -39,12 +81,65 @@ func (ln *LayerNorm) Apply(x [][]float32) [][]float32 {
 		variance /= float32(len(vec))
 
 		stdDev := float32(math.Sqrt(float64(variance) + float64(ln.Eps)))
-		output[i] = make([]float32, len(vec))
+		ln.invStdDev[i] = 1 / stdDev
 
+		out := output.Row(i)
+		xhat := ln.normalized.Row(i)
 		for j, v := range vec {
 			normalized := (v - mean) / stdDev
-			output[i][j] = normalized*ln.Gamma[j] + ln.Beta[j]
+			xhat[j] = normalized
+			out[j] = normalized*gamma[j] + beta[j]
 		}
 	}
 	return output
 }
+
+// Backward accumulates the gain and bias gradients for the last Apply call
+// and returns the gradient with respect to its input.
+func (ln *LayerNorm) Backward(dOut *Tensor) *Tensor {
+	ln.ensureGrads()
+	dx := NewTensor(dOut.Shape...)
+	gamma := ln.Gamma.Data
+
+	for i := 0; i < dOut.Rows(); i++ {
+		dy := dOut.Row(i)
+		xhat := ln.normalized.Row(i)
+		n := float32(len(dy))
+
+		// Mean of dxhat and of dxhat*xhat over the feature dimension
+		var meanD, meanDX float32
+		for j, g := range dy {
+			ln.GammaGrad.Data[j] += g * xhat[j]
+			ln.BetaGrad.Data[j] += g
+			d := g * gamma[j]
+			meanD += d
+			meanDX += d * xhat[j]
+		}
+		meanD /= n
+		meanDX /= n
+
+		dxi := dx.Row(i)
+		for j, g := range dy {
+			d := g * gamma[j]
+			dxi[j] = ln.invStdDev[i] * (d - meanD - xhat[j]*meanDX)
+		}
+	}
+	return dx
+}
+
+func (ln *LayerNorm) ensureGrads() {
+	if ln.GammaGrad == nil {
+		ln.GammaGrad = NewTensor(ln.Gamma.Shape...)
+	}
+	if ln.BetaGrad == nil {
+		ln.BetaGrad = NewTensor(ln.Beta.Shape...)
+	}
+}
+
+func (ln *LayerNorm) parameters(prefix string) []*Parameter {
+	ln.ensureGrads()
+	return []*Parameter{
+		{Name: prefix + "_gamma", Value: ln.Gamma, Grad: ln.GammaGrad},
+		{Name: prefix + "_beta", Value: ln.Beta, Grad: ln.BetaGrad},
+	}
+}
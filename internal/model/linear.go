@@ -1,53 +1,76 @@
 package model
 
-import (
-	"math/rand"
-)
+import "math/rand"
 
 type Linear struct {
 	InFeatures  int
 	OutFeatures int
-	Weight      [][]float32
-	Bias        []float32
+	Weight      *Tensor // [OutFeatures, InFeatures]
+	Bias        *Tensor // [OutFeatures]
+
+	// Gradients accumulated by Backward, allocated on first use
+	WeightGrad *Tensor
+	BiasGrad   *Tensor
+
+	input *Tensor
 }
 
-func NewLinear(inFeatures, outFeatures int) *Linear {
+// NewLinear creates a layer with weights drawn from rng
+func NewLinear(inFeatures, outFeatures int, rng *rand.Rand) *Linear {
 	l := &Linear{
 		InFeatures:  inFeatures,
 		OutFeatures: outFeatures,
-		Weight:      make([][]float32, outFeatures),
-		Bias:        make([]float32, outFeatures),
+		Weight:      NewTensor(outFeatures, inFeatures),
+		Bias:        NewTensor(outFeatures),
 	}
 
-	scale := float32(0.02)
-	for i := range l.Weight {
-		l.Weight[i] = make([]float32, inFeatures)
-		for j := range l.Weight[i] {
-			l.Weight[i][j] = (rand.Float32()*2 - 1) * scale
+	uniformInit(l.Weight, 0.02, rng)
+
+	return l
+}
+
+func (l *Linear) Forward(x *Tensor) *Tensor {
+	l.input = x
+
+	result := MatMul(x, l.Weight.Transpose())
+	for b := 0; b < result.Rows(); b++ {
+		out := result.Row(b)
+		for i, bias := range l.Bias.Data {
+			out[i] += bias
 		}
 	}
 
-	for i := range l.Bias {
-		l.Bias[i] = 0
+	return result
+}
+
+// Backward accumulates the weight and bias gradients for the input seen by
+// the last Forward call and returns the gradient with respect to that input.
+func (l *Linear) Backward(dOut *Tensor) *Tensor {
+	l.ensureGrads()
+
+	for b := 0; b < dOut.Rows(); b++ {
+		for i, g := range dOut.Row(b) {
+			l.BiasGrad.Data[i] += g
+		}
 	}
+	matMulAdd(l.WeightGrad, dOut.Transpose(), l.input)
 
-	return l
+	return MatMul(dOut, l.Weight)
 }
 
-func (l *Linear) Forward(x [][]float32) [][]float32 {
-	batchSize := len(x)
-	result := make([][]float32, batchSize)
-
-	for b := 0; b < batchSize; b++ {
-		result[b] = make([]float32, l.OutFeatures)
-		for i := 0; i < l.OutFeatures; i++ {
-			sum := l.Bias[i]
-			for j := 0; j < l.InFeatures; j++ {
-				sum += x[b][j] * l.Weight[i][j]
-			}
-			result[b][i] = sum
-		}
+func (l *Linear) ensureGrads() {
+	if l.WeightGrad == nil {
+		l.WeightGrad = NewTensor(l.OutFeatures, l.InFeatures)
 	}
+	if l.BiasGrad == nil {
+		l.BiasGrad = NewTensor(l.OutFeatures)
+	}
+}
 
-	return result
+func (l *Linear) parameters(prefix string) []*Parameter {
+	l.ensureGrads()
+	return []*Parameter{
+		{Name: prefix + "_weight", Value: l.Weight, Grad: l.WeightGrad},
+		{Name: prefix + "_bias", Value: l.Bias, Grad: l.BiasGrad},
+	}
 }
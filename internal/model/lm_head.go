@@ -1,86 +1,73 @@
 package model
 
 import (
-	"math"
 	"math/rand"
 )
 
 type LMHead struct {
 	linear *Linear
+
+	// tied is set when the projection is the token embedding matrix
+	tied bool
 }
 
-func NewLMHead(embedDim, vocabSize int) *LMHead {
+func NewLMHead(embedDim, vocabSize int, rng *rand.Rand) *LMHead {
 	return &LMHead{
-		linear: NewLinear(embedDim, vocabSize),
+		linear: NewLinear(embedDim, vocabSize, rng),
 	}
 }
 
-func (lm *LMHead) Forward(x [][]float32) [][]float32 {
-	logits := lm.linear.Forward(x)
+// Forward projects hidden states onto the vocabulary and returns raw,
+// unnormalized logits.
+func (lm *LMHead) Forward(x *Tensor) *Tensor {
+	return lm.linear.Forward(x)
+}
 
-	for i := range logits {
-		maxLogit := float32(math.Inf(-1))
-		for _, v := range logits[i] {
-			if v > maxLogit {
-				maxLogit = v
-			}
-		}
+// Backward accumulates the projection gradients for dLogits and returns the
+// gradient of the hidden states.
+func (lm *LMHead) Backward(dLogits *Tensor) *Tensor {
+	return lm.linear.Backward(dLogits)
+}
 
-		sum := float32(0)
-		for j := range logits[i] {
-			logits[i][j] = float32(math.Exp(float64(logits[i][j] - maxLogit)))
-			sum += logits[i][j]
-		}
+// tie makes the projection share the token embedding matrix of e, and its
+// gradient, so the two are trained as one parameter
+func (lm *LMHead) tie(e *Embeddings) {
+	e.ensureGrads()
+	lm.linear.Weight = e.TokenEmbed
+	lm.linear.WeightGrad = e.TokenEmbedGrad
+	lm.tied = true
+}
 
-		for j := range logits[i] {
-			logits[i][j] /= sum
-		}
+func (lm *LMHead) parameters() []*Parameter {
+	params := lm.linear.parameters("lm_head")
+	if lm.tied {
+		// The weight is reported once, as token_embeddings
+		return params[1:]
 	}
-
-	return logits
+	return params
 }
 
-func (lm *LMHead) Sample(probs []float32, temperature float32) int {
-	logits := make([]float32, len(probs))
-	maxLogit := float32(math.Inf(-1))
-
-	for i, p := range probs {
-		if p > 0 {
-			logits[i] = float32(math.Log(float64(p))) / temperature
-		} else {
-			logits[i] = float32(math.Inf(-1))
-		}
-		if logits[i] > maxLogit {
-			maxLogit = logits[i]
-		}
+// Sample picks the next token from logits according to params, drawing from
+// rng. Greedy decoding never consumes randomness.
+func (lm *LMHead) Sample(logits []float32, params SamplingParams, rng *rand.Rand) int {
+	if params.Temperature <= 0 {
+		return argmax(logits)
 	}
 
-	sum := float32(0)
-	for i := range logits {
-		logits[i] = float32(math.Exp(float64(logits[i] - maxLogit)))
-		sum += logits[i]
-	}
+	candidates := params.filter(softmax(logits, params.Temperature))
 
-	for i := range logits {
-		logits[i] /= sum
+	var total float32
+	for _, c := range candidates {
+		total += c.prob
 	}
 
-	r := rand.Float32()
+	r := rng.Float32() * total
 	cumsum := float32(0)
-	for i, p := range logits {
-		cumsum += p
+	for _, c := range candidates {
+		cumsum += c.prob
 		if r < cumsum {
-			return i
-		}
-	}
-
-	maxIdx := 0
-	maxProb := float32(-1)
-	for i, p := range logits {
-		if p > maxProb {
-			maxProb = p
-			maxIdx = i
+			return c.id
 		}
 	}
-	return maxIdx
+	return candidates[0].id
 }
@@ -5,13 +5,13 @@ import (
 )
 
 // CrossEntropyLoss calculates the cross entropy loss between logits and target indices
-func CrossEntropyLoss(logits [][]float32, targets []int) float32 {
+func CrossEntropyLoss(logits *Tensor, targets []int) float32 {
 	var loss float32
-	batchSize := len(logits)
-	vocabSize := len(logits[0])
+	batchSize := logits.Rows()
+	vocabSize := logits.Cols()
 
 	for i := 0; i < batchSize; i++ {
-		currentLogits := logits[i]
+		currentLogits := logits.Row(i)
 		target := targets[i]
 
 		maxLogit := float32(math.Inf(-1))
@@ -35,3 +35,45 @@ func CrossEntropyLoss(logits [][]float32, targets []int) float32 {
 
 	return loss / float32(batchSize)
 }
+
+// CrossEntropyLossGrad returns the same loss as CrossEntropyLoss together
+// with its gradient with respect to the logits. Targets outside the
+// vocabulary are ignored and receive a zero gradient.
+func CrossEntropyLossGrad(logits *Tensor, targets []int) (float32, *Tensor) {
+	var loss float32
+	batchSize := logits.Rows()
+	vocabSize := logits.Cols()
+	grad := NewTensor(batchSize, vocabSize)
+
+	for i := 0; i < batchSize; i++ {
+		currentLogits := logits.Row(i)
+		target := targets[i]
+		if target < 0 || target >= vocabSize {
+			continue
+		}
+
+		maxLogit := float32(math.Inf(-1))
+		for _, l := range currentLogits {
+			if l > maxLogit {
+				maxLogit = l
+			}
+		}
+
+		g := grad.Row(i)
+		sumExp := float32(0)
+		for j, l := range currentLogits {
+			g[j] = float32(math.Exp(float64(l - maxLogit)))
+			sumExp += g[j]
+		}
+
+		logSumExp := float32(math.Log(float64(sumExp))) + maxLogit
+		loss += logSumExp - currentLogits[target]
+
+		for j := range g {
+			g[j] /= sumExp * float32(batchSize)
+		}
+		g[target] -= 1 / float32(batchSize)
+	}
+
+	return loss / float32(batchSize), grad
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package model
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the whole of f into memory on platforms without mmap
+func mapFile(f *os.File) ([]byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
+// unmapFile leaves data to the garbage collector
+func unmapFile(data []byte) error {
+	return nil
+}
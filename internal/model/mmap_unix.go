@@ -0,0 +1,32 @@
+//go:build unix
+
+package model
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the whole of f into memory. The mapping is private and
+// writable, so the weights can be updated in place without touching the file.
+// It stays valid until passed to unmapFile.
+func mapFile(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(info.Size()),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+}
+
+// unmapFile releases a mapping returned by mapFile. Nothing may refer to its
+// memory afterwards.
+func unmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
@@ -2,56 +2,25 @@ package model
 
 import (
 	"fmt"
-	"math"
 	"math/rand"
 )
 
-// Matrix multiplication
-func matMul(a, b [][]float32) [][]float32 {
-	n := len(a)
-	m := len(b[0])
-	p := len(b)
-	result := make([][]float32, n)
-
-	if len(a) == 0 || len(b) == 0 || len(a[0]) != len(b) {
-		panic(fmt.Sprintf("matMul dimension mismatch: a(%dx%d), b(%dx%d)", len(a), len(a[0]), len(b), len(b[0])))
+// Elementwise addition of two tensors of the same shape
+func addTensors(a, b *Tensor) *Tensor {
+	if !a.SameShape(b) {
+		panic(fmt.Sprintf("addTensors shape mismatch: %v and %v", a.Shape, b.Shape))
 	}
-	for i := range result {
-		result[i] = make([]float32, m)
-		for j := range result[i] {
-			var sum float32
-			for k := 0; k < p; k++ {
-				sum += a[i][k] * b[k][j]
-			}
-			result[i][j] = sum
-		}
+	result := NewTensor(a.Shape...)
+	av, bv := a.Values(), b.Values()
+	for i := range result.Data {
+		result.Data[i] = av[i] + bv[i]
 	}
 	return result
 }
 
-// Vector addition
-func addVectors(a, b [][]float32) [][]float32 {
-	result := make([][]float32, len(a))
-	for i := range a {
-		result[i] = make([]float32, len(a[i]))
-		for j := range a[i] {
-			result[i][j] = a[i][j] + b[i][j]
-		}
-	}
-	return result
-}
-
-// Xavier initialization
-func xavierInit(shape [][]float32) {
-	fanIn := len(shape)
-	if fanIn == 0 {
-		return
-	}
-
-	for i := range shape {
-		for j := range shape[i] {
-			stddev := float32(math.Sqrt(2.0 / float64(fanIn)))
-			shape[i][j] = rand.Float32() * stddev
-		}
+// Uniform initialization in [-scale, scale]
+func uniformInit(t *Tensor, scale float32, rng *rand.Rand) {
+	for i := range t.Data {
+		t.Data[i] = (rng.Float32()*2 - 1) * scale
 	}
 }
@@ -0,0 +1,19 @@
+package model
+
+// Parameter is a trainable tensor of the model together with the gradient
+// accumulated for it by Backward
+type Parameter struct {
+	Name  string
+	Value *Tensor
+	Grad  *Tensor
+}
+
+// Size returns the number of scalar values held by the parameter
+func (p *Parameter) Size() int {
+	return p.Value.Size()
+}
+
+// ZeroGrad resets the accumulated gradient to zero
+func (p *Parameter) ZeroGrad() {
+	p.Grad.Zero()
+}
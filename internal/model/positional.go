@@ -0,0 +1,99 @@
+package model
+
+import "math"
+
+// Values of Config.PositionEncoding
+const (
+	// PositionLearned adds a trained embedding for each position up to
+	// ContextSize, as in GPT-2
+	PositionLearned = "learned"
+	// PositionSinusoidal adds the fixed sine and cosine encodings of the
+	// original Transformer
+	PositionSinusoidal = "sinusoidal"
+	// PositionRoPE rotates queries and keys by their position inside the
+	// attention, so scores depend only on relative distance
+	PositionRoPE = "rope"
+	// PositionALiBi penalizes attention scores linearly with the distance
+	// between query and key, with a different slope for each head
+	PositionALiBi = "alibi"
+)
+
+// positionBase is the wavelength scale of the sinusoidal and rotary encodings
+const positionBase = 10000
+
+// sinusoidalEncoding returns the encodings of positions: dimension 2i holds
+// sin(p / base^(2i/dim)) and dimension 2i+1 the matching cosine
+func sinusoidalEncoding(positions []int, dim int) *Tensor {
+	result := NewTensor(len(positions), dim)
+	for i, p := range positions {
+		row := result.Row(i)
+		for j := 0; j < dim; j += 2 {
+			angle := float64(p) / math.Pow(positionBase, float64(j)/float64(dim))
+			sin, cos := math.Sincos(angle)
+			row[j] = float32(sin)
+			if j+1 < dim {
+				row[j+1] = float32(cos)
+			}
+		}
+	}
+	return result
+}
+
+// Rotary applies rotary position embeddings to the heads of queries and keys.
+// Dimension j of a head is paired with dimension j+HeadDim/2, and each pair
+// is rotated by an angle proportional to the position.
+type Rotary struct {
+	HeadDim int
+	freqs   []float64 // radians per position of each pair
+}
+
+// NewRotary returns the rotary embedding for heads of headDim dimensions,
+// which must be even
+func NewRotary(headDim int) *Rotary {
+	r := &Rotary{HeadDim: headDim, freqs: make([]float64, headDim/2)}
+	for j := range r.freqs {
+		r.freqs[j] = math.Pow(positionBase, -2*float64(j)/float64(headDim))
+	}
+	return r
+}
+
+// rotate rotates, in place, every head of the rows of x, which sit at
+// positions start, start+1, ... With inverse it undoes the rotation, which
+// is also how a gradient passes back through it.
+func (r *Rotary) rotate(x *Tensor, start int, inverse bool) {
+	half := r.HeadDim / 2
+	for i := 0; i < x.Rows(); i++ {
+		row := x.Row(i)
+		for j, freq := range r.freqs {
+			sin, cos := math.Sincos(float64(start+i) * freq)
+			if inverse {
+				sin = -sin
+			}
+			s, c := float32(sin), float32(cos)
+			for h := 0; h < len(row); h += r.HeadDim {
+				a, b := row[h+j], row[h+j+half]
+				row[h+j] = a*c - b*s
+				row[h+j+half] = a*s + b*c
+			}
+		}
+	}
+}
+
+// alibiSlopes returns the ALiBi slope of each of numHeads heads: a geometric
+// sequence starting at 2^(-8/n) for the largest power of two n up to
+// numHeads, with any further heads taking every other slope of the sequence
+// for 2n
+func alibiSlopes(numHeads int) []float32 {
+	n := 1
+	for n*2 <= numHeads {
+		n *= 2
+	}
+	slopes := make([]float32, 0, numHeads)
+	for i := 1; i <= n; i++ {
+		slopes = append(slopes, float32(math.Pow(2, -8*float64(i)/float64(n))))
+	}
+	for i := 1; len(slopes) < numHeads; i += 2 {
+		slopes = append(slopes, float32(math.Pow(2, -4*float64(i)/float64(n))))
+	}
+	return slopes
+}
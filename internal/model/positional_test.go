@@ -0,0 +1,61 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAlibiSlopes(t *testing.T) {
+	for _, tt := range []struct {
+		heads int
+		want  []float64 // exponents of 2
+	}{
+		{1, []float64{-8}},
+		{4, []float64{-2, -4, -6, -8}},
+		{6, []float64{-2, -4, -6, -8, -1, -3}},
+	} {
+		got := alibiSlopes(tt.heads)
+		if len(got) != len(tt.want) {
+			t.Fatalf("alibiSlopes(%d) returned %d slopes, want %d", tt.heads, len(got), len(tt.want))
+		}
+		for i, exp := range tt.want {
+			if want := float32(math.Pow(2, exp)); got[i] != want {
+				t.Errorf("alibiSlopes(%d)[%d] = %g, want %g", tt.heads, i, got[i], want)
+			}
+		}
+	}
+}
+
+func TestRotary_ScoresDependOnDistanceOnly(t *testing.T) {
+	r := NewRotary(4)
+	q := []float32{0.3, -1.2, 0.7, 0.5}
+	k := []float32{-0.4, 0.9, 1.1, -0.2}
+
+	score := func(qPos, kPos int) float32 {
+		qr := TensorFrom(append([]float32(nil), q...), 1, 4)
+		kr := TensorFrom(append([]float32(nil), k...), 1, 4)
+		r.rotate(qr, qPos, false)
+		r.rotate(kr, kPos, false)
+		var sum float32
+		for i := range q {
+			sum += qr.Data[i] * kr.Data[i]
+		}
+		return sum
+	}
+
+	want := score(3, 1)
+	for _, start := range []int{0, 7, 100} {
+		if got := score(start+2, start); math.Abs(float64(got-want)) > 1e-4 {
+			t.Errorf("score at positions %d and %d = %g, want %g", start+2, start, got, want)
+		}
+	}
+
+	x := TensorFrom(append([]float32(nil), q...), 1, 4)
+	r.rotate(x, 5, false)
+	r.rotate(x, 5, true)
+	for i := range q {
+		if math.Abs(float64(x.Data[i]-q[i])) > 1e-6 {
+			t.Fatalf("inverse rotation gave %v, want %v", x.Data, q)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package model
+
+import "math"
+
+// RMSNorm scales each row by the inverse of its root mean square and a
+// learned gain. Unlike LayerNorm it neither centers the row nor adds a bias.
+type RMSNorm struct {
+	Gamma *Tensor
+	Eps   float32
+
+	// Gradient accumulated by Backward, allocated on first use
+	GammaGrad *Tensor
+
+	// Normalized inputs and inverse root mean squares of the last Apply
+	normalized *Tensor
+	invRMS     []float32
+}
+
+func NewRMSNorm(dim int) *RMSNorm {
+	rn := &RMSNorm{
+		Gamma: NewTensor(dim),
+		Eps:   1e-5,
+	}
+
+	for i := range rn.Gamma.Data {
+		rn.Gamma.Data[i] = 1
+	}
+	return rn
+}
+
+func (rn *RMSNorm) Apply(x *Tensor) *Tensor {
+	output := NewTensor(x.Shape...)
+	rn.normalized = NewTensor(x.Shape...)
+	rn.invRMS = make([]float32, x.Rows())
+	gamma := rn.Gamma.Data
+
+	for i := 0; i < x.Rows(); i++ {
+		vec := x.Row(i)
+		var meanSquare float32
+		for _, v := range vec {
+			meanSquare += v * v
+		}
+		meanSquare /= float32(len(vec))
+
+		inv := float32(1 / math.Sqrt(float64(meanSquare)+float64(rn.Eps)))
+		rn.invRMS[i] = inv
+
+		out := output.Row(i)
+		xhat := rn.normalized.Row(i)
+		for j, v := range vec {
+			xhat[j] = v * inv
+			out[j] = xhat[j] * gamma[j]
+		}
+	}
+	return output
+}
+
+// Backward accumulates the gain gradient for the last Apply call and returns
+// the gradient with respect to its input.
+func (rn *RMSNorm) Backward(dOut *Tensor) *Tensor {
+	rn.ensureGrads()
+	dx := NewTensor(dOut.Shape...)
+	gamma := rn.Gamma.Data
+
+	for i := 0; i < dOut.Rows(); i++ {
+		dy := dOut.Row(i)
+		xhat := rn.normalized.Row(i)
+
+		// Mean of dxhat*xhat over the feature dimension
+		var meanDX float32
+		for j, g := range dy {
+			rn.GammaGrad.Data[j] += g * xhat[j]
+			meanDX += g * gamma[j] * xhat[j]
+		}
+		meanDX /= float32(len(dy))
+
+		dxi := dx.Row(i)
+		for j, g := range dy {
+			dxi[j] = rn.invRMS[i] * (g*gamma[j] - xhat[j]*meanDX)
+		}
+	}
+	return dx
+}
+
+func (rn *RMSNorm) ensureGrads() {
+	if rn.GammaGrad == nil {
+		rn.GammaGrad = NewTensor(rn.Gamma.Shape...)
+	}
+}
+
+func (rn *RMSNorm) parameters(prefix string) []*Parameter {
+	rn.ensureGrads()
+	return []*Parameter{
+		{Name: prefix + "_gamma", Value: rn.Gamma, Grad: rn.GammaGrad},
+	}
+}
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Safetensors files hold every parameter under its name in Parameters, which
+// follows the JSON field names of ModelState:
+//
+//	token_embeddings                      [vocab_size, embed_dim]
+//	position_embeddings                   [context_size, embed_dim], learned positions only
+//	layers.N.qkv_proj_weight              [embed_dim+2*kv_dim, embed_dim]
+//	layers.N.qkv_proj_bias                [embed_dim+2*kv_dim]
+//	layers.N.out_proj_weight              [embed_dim, embed_dim]
+//	layers.N.out_proj_bias                [embed_dim]
+//	layers.N.norm1_gamma, norm1_beta      [embed_dim]
+//	layers.N.ff1_weight                   [ff1_dim, embed_dim]
+//	layers.N.ff1_bias                     [ff1_dim]
+//	layers.N.ff2_weight                   [embed_dim, ffn_hidden_dim]
+//	layers.N.ff2_bias                     [embed_dim]
+//	layers.N.norm2_gamma, norm2_beta      [embed_dim]
+//	final_norm_gamma, final_norm_beta     [embed_dim]
+//	lm_head_weight                        [vocab_size, embed_dim]
+//	lm_head_bias                          [vocab_size]
+//
+// Linear weights are stored [out, in] like torch.nn.Linear, and the query,
+// key and value projections are concatenated in that order. kv_dim is
+// num_kv_heads*embed_dim/num_heads, which is embed_dim unless the model
+// shares key and value heads. ff1_dim is ffn_hidden_dim, or twice that for
+// gated activations, whose ff1 holds the gate followed by the value. Models
+// using RMSNorm have no norm betas. All tensors are F32. The model config is
+// kept in the __metadata__ section, see configMetadata. When the config ties
+// the embeddings, lm_head_weight is omitted.
+
+// safetensorsEntry describes one tensor in a safetensors header
+type safetensorsEntry struct {
+	Dtype       string   `json:"dtype"`
+	Shape       []int    `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+const safetensorsMetadataKey = "__metadata__"
+
+// configMetadata encodes cfg as safetensors metadata
+func configMetadata(cfg Config) map[string]string {
+	return map[string]string{
+		"vocab_size":        strconv.Itoa(cfg.VocabSize),
+		"context_size":      strconv.Itoa(cfg.ContextSize),
+		"embed_dim":         strconv.Itoa(cfg.EmbedDim),
+		"num_heads":         strconv.Itoa(cfg.NumHeads),
+		"num_layers":        strconv.Itoa(cfg.NumLayers),
+		"norm_position":     cfg.NormPosition,
+		"tie_embeddings":    strconv.FormatBool(cfg.TieEmbeddings),
+		"position_encoding": cfg.PositionEncoding,
+		"num_kv_heads":      strconv.Itoa(cfg.NumKVHeads),
+		"activation":        cfg.Activation,
+		"ffn_hidden_dim":    strconv.Itoa(cfg.FFNHiddenDim),
+		"norm_type":         cfg.NormType,
+	}
+}
+
+// SaveSafetensors saves model weights to a safetensors file
+func (g *GPT2) SaveSafetensors(path string) error {
+	params := g.Parameters()
+
+	metadata := configMetadata(g.config)
+	metadata["format"] = "gollm"
+
+	header := map[string]any{safetensorsMetadataKey: metadata}
+	var offset int64
+	for _, p := range params {
+		size := int64(p.Size()) * 4
+		header[p.Name] = safetensorsEntry{
+			Dtype:       "F32",
+			Shape:       p.Value.Shape,
+			DataOffsets: [2]int64{offset, offset + size},
+		}
+		offset += size
+	}
+
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode header: %v", err)
+	}
+	// The header may be padded with spaces; aligning the data to 8 bytes lets
+	// readers use it in place
+	for len(headerData)%8 != 0 {
+		headerData = append(headerData, ' ')
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(headerData))); err != nil {
+			return fmt.Errorf("failed to write header length: %v", err)
+		}
+		if _, err := w.Write(headerData); err != nil {
+			return fmt.Errorf("failed to write header: %v", err)
+		}
+		return writeTensors(w, params)
+	})
+}
+
+// LoadSafetensors loads model weights from a safetensors file named as
+// described above. If the file records a model config, it must match the
+// model's. The file is memory-mapped like a version 2 checkpoint.
+func (g *GPT2) LoadSafetensors(path string) error {
+	tensors, metadata, mapping, err := readSafetensors(path)
+	if err != nil {
+		return err
+	}
+	if err := g.bindSafetensors(tensors, metadata); err != nil {
+		unmapFile(mapping)
+		return err
+	}
+	return g.replaceMapping(mapping)
+}
+
+// bindSafetensors checks the config in metadata, if any, and binds every
+// parameter to its tensor
+func (g *GPT2) bindSafetensors(tensors map[string]rawTensor, metadata map[string]string) error {
+	if _, ok := metadata["vocab_size"]; ok {
+		cfg, err := configFromMetadata(metadata)
+		if err != nil {
+			return err
+		}
+		if err := g.matchConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	return g.bindTensors(tensors)
+}
+
+// readSafetensors maps a safetensors file and returns its tensors by name
+// together with its metadata and the mapping, which the tensors refer to
+// until it is passed to unmapFile. Tensors of other dtypes than F32 are
+// listed without data, so files holding unused buffers of other types still
+// load.
+func readSafetensors(path string) (map[string]rawTensor, map[string]string, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	mapping, err := mapFile(f)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to map file: %v", err)
+	}
+	tensors, metadata, err := parseSafetensors(mapping)
+	if err != nil {
+		unmapFile(mapping)
+		return nil, nil, nil, err
+	}
+	return tensors, metadata, mapping, nil
+}
+
+// parseSafetensors returns the tensors and metadata of data, the whole
+// safetensors file
+func parseSafetensors(data []byte) (map[string]rawTensor, map[string]string, error) {
+	entries, metadata, err := parseSafetensorsHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	headerLen := binary.LittleEndian.Uint64(data)
+	data = data[8+headerLen:]
+
+	tensors := make(map[string]rawTensor, len(entries))
+	for name, e := range entries {
+		if e.Dtype != "F32" {
+			tensors[name] = rawTensor{Dtype: e.Dtype, Shape: e.Shape}
+			continue
+		}
+		begin, end := e.DataOffsets[0], e.DataOffsets[1]
+		if begin < 0 || end < begin || end > int64(len(data)) {
+			return nil, nil, fmt.Errorf("tensor %s: data offsets out of range", name)
+		}
+		if end-begin != int64(shapeSize(e.Shape))*4 {
+			return nil, nil, fmt.Errorf("tensor %s: %d bytes do not match shape %v", name, end-begin, e.Shape)
+		}
+		tensors[name] = rawTensor{Dtype: e.Dtype, Shape: e.Shape, Data: data[begin:end]}
+	}
+
+	return tensors, metadata, nil
+}
+
+// parseSafetensorsHeader decodes the header at the start of data
+func parseSafetensorsHeader(data []byte) (map[string]safetensorsEntry, map[string]string, error) {
+	if len(data) < 8 {
+		return nil, nil, fmt.Errorf("invalid safetensors file: too short")
+	}
+	headerLen := binary.LittleEndian.Uint64(data)
+	if headerLen > uint64(len(data)-8) {
+		return nil, nil, fmt.Errorf("invalid safetensors file: header length %d exceeds file size", headerLen)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data[8:8+headerLen], &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode safetensors header: %v", err)
+	}
+
+	var metadata map[string]string
+	entries := make(map[string]safetensorsEntry, len(raw))
+	for name, msg := range raw {
+		if name == safetensorsMetadataKey {
+			if err := json.Unmarshal(msg, &metadata); err != nil {
+				return nil, nil, fmt.Errorf("failed to decode safetensors metadata: %v", err)
+			}
+			continue
+		}
+		var e safetensorsEntry
+		if err := json.Unmarshal(msg, &e); err != nil {
+			return nil, nil, fmt.Errorf("tensor %s: %v", name, err)
+		}
+		entries[name] = e
+	}
+
+	return entries, metadata, nil
+}
+
+// configFromMetadata rebuilds the model config stored by SaveSafetensors.
+// Keys added after the first release are optional.
+func configFromMetadata(metadata map[string]string) (Config, error) {
+	var cfg Config
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"vocab_size", &cfg.VocabSize},
+		{"context_size", &cfg.ContextSize},
+		{"embed_dim", &cfg.EmbedDim},
+		{"num_heads", &cfg.NumHeads},
+		{"num_layers", &cfg.NumLayers},
+	}
+	for _, m := range ints {
+		value, ok := metadata[m.key]
+		if !ok {
+			return Config{}, fmt.Errorf("metadata is missing %s", m.key)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("metadata %s: %v", m.key, err)
+		}
+		*m.dst = n
+	}
+
+	cfg.NormPosition = metadata["norm_position"]
+	cfg.PositionEncoding = metadata["position_encoding"]
+	cfg.Activation = metadata["activation"]
+	cfg.NormType = metadata["norm_type"]
+	optionalInts := []struct {
+		key string
+		dst *int
+	}{
+		{"num_kv_heads", &cfg.NumKVHeads},
+		{"ffn_hidden_dim", &cfg.FFNHiddenDim},
+	}
+	for _, m := range optionalInts {
+		if value, ok := metadata[m.key]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("metadata %s: %v", m.key, err)
+			}
+			*m.dst = n
+		}
+	}
+	if value, ok := metadata["tie_embeddings"]; ok {
+		tie, err := strconv.ParseBool(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("metadata tie_embeddings: %v", err)
+		}
+		cfg.TieEmbeddings = tie
+	}
+
+	return cfg.withDefaults(), nil
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fixtureConfig is the smallest model the safetensors fixture describes
+func fixtureConfig() Config {
+	return Config{VocabSize: 3, ContextSize: 2, EmbedDim: 2, NumHeads: 1, NumLayers: 1}
+}
+
+// fixtureTensors lists the tensors of fixtureConfig in the documented naming
+// scheme, in a different order than Parameters returns them
+var fixtureTensors = []struct {
+	name  string
+	shape []int
+}{
+	{"lm_head_bias", []int{3}},
+	{"lm_head_weight", []int{3, 2}},
+	{"final_norm_beta", []int{2}},
+	{"final_norm_gamma", []int{2}},
+	{"layers.0.norm2_beta", []int{2}},
+	{"layers.0.norm2_gamma", []int{2}},
+	{"layers.0.ff2_bias", []int{2}},
+	{"layers.0.ff2_weight", []int{2, 8}},
+	{"layers.0.ff1_bias", []int{8}},
+	{"layers.0.ff1_weight", []int{8, 2}},
+	{"layers.0.norm1_beta", []int{2}},
+	{"layers.0.norm1_gamma", []int{2}},
+	{"layers.0.out_proj_bias", []int{2}},
+	{"layers.0.out_proj_weight", []int{2, 2}},
+	{"layers.0.qkv_proj_bias", []int{6}},
+	{"layers.0.qkv_proj_weight", []int{6, 2}},
+	{"position_embeddings", []int{2, 2}},
+	{"token_embeddings", []int{3, 2}},
+}
+
+// writeFixture builds a safetensors file by hand, independently of
+// SaveSafetensors. Every value is its index in the data section, so each
+// tensor's contents reveal where it was read from.
+func writeFixture(t *testing.T, metadata string) string {
+	t.Helper()
+
+	var entries []string
+	var offset, count int
+	for _, ft := range fixtureTensors {
+		size := shapeSize(ft.shape) * 4
+		var dims []string
+		for _, d := range ft.shape {
+			dims = append(dims, strconv.Itoa(d))
+		}
+		entries = append(entries, fmt.Sprintf(`"%s":{"dtype":"F32","shape":[%s],"data_offsets":[%d,%d]}`,
+			ft.name, strings.Join(dims, ","), offset, offset+size))
+		offset += size
+		count += size / 4
+	}
+	if metadata != "" {
+		entries = append(entries, `"__metadata__":`+metadata)
+	}
+	header := "{" + strings.Join(entries, ",") + "}"
+
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(len(header)))
+	buf = append(buf, header...)
+	for i := 0; i < count; i++ {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(i)))
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.safetensors")
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGPT2_LoadSafetensorsFixture(t *testing.T) {
+	for _, metadata := range []string{
+		`{"format":"gollm","vocab_size":"3","context_size":"2","embed_dim":"2","num_heads":"1","num_layers":"1"}`,
+		"",
+	} {
+		path := writeFixture(t, metadata)
+		g := NewGPT2(fixtureConfig(), testRand())
+		if err := g.LoadSafetensors(path); err != nil {
+			t.Fatalf("LoadSafetensors() error = %v", err)
+		}
+
+		// lm_head_bias comes first in the file, token_embeddings last
+		if got := g.lmHead.linear.Bias.Values(); got[0] != 0 || got[2] != 2 {
+			t.Errorf("lm_head_bias = %v, want [0 1 2]", got)
+		}
+		if got := g.lmHead.linear.Weight.Row(1); got[0] != 5 || got[1] != 6 {
+			t.Errorf("lm_head_weight row 1 = %v, want [5 6]", got)
+		}
+		if got := g.embeddings.TokenEmbed.Row(2); got[0] != 95 || got[1] != 96 {
+			t.Errorf("token_embeddings row 2 = %v, want [95 96]", got)
+		}
+		if got := g.layers[0].Attention.QKVProj.Bias.Values(); got[0] != 69 {
+			t.Errorf("layers.0.qkv_proj_bias[0] = %v, want 69", got[0])
+		}
+	}
+}
+
+func TestGPT2_LoadSafetensorsRejectsMismatch(t *testing.T) {
+	path := writeFixture(t, "")
+	if err := NewGPT2(tinyConfig(), testRand()).LoadSafetensors(path); err == nil {
+		t.Errorf("LoadSafetensors() into a model of other shapes succeeded")
+	}
+
+	path = writeFixture(t, `{"vocab_size":"3","context_size":"2","embed_dim":"2","num_heads":"2","num_layers":"1"}`)
+	if err := NewGPT2(fixtureConfig(), testRand()).LoadSafetensors(path); err == nil {
+		t.Errorf("LoadSafetensors() ignored a different config in the metadata")
+	}
+}
+
+func TestGPT2_SafetensorsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	g := NewGPT2(tinyConfig(), testRand())
+	if err := g.SaveSafetensors(path); err != nil {
+		t.Fatalf("SaveSafetensors() error = %v", err)
+	}
+
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	if cfg != g.config {
+		t.Errorf("ReadConfig() = %+v, want %+v", cfg, g.config)
+	}
+
+	loaded := NewGPT2(tinyConfig(), rand.New(rand.NewSource(1000)))
+	if err := loaded.LoadSafetensors(path); err != nil {
+		t.Fatalf("LoadSafetensors() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+}
+
+func TestGPT2_SafetensorsRoundTripLlamaStyle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	g := NewGPT2(llamaConfig(), testRand())
+	if err := g.SaveSafetensors(path); err != nil {
+		t.Fatalf("SaveSafetensors() error = %v", err)
+	}
+
+	tensors, _, mapping, err := readSafetensors(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unmapFile(mapping)
+	for name := range tensors {
+		if strings.HasSuffix(name, "_beta") || name == "position_embeddings" {
+			t.Errorf("unexpected tensor %s", name)
+		}
+	}
+
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	if cfg != g.config {
+		t.Errorf("ReadConfig() = %+v, want %+v", cfg, g.config)
+	}
+	loaded := NewGPT2(cfg, rand.New(rand.NewSource(1000)))
+	if err := loaded.LoadSafetensors(path); err != nil {
+		t.Fatalf("LoadSafetensors() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+}
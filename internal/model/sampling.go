@@ -0,0 +1,275 @@
+package model
+
+import (
+	"math"
+	"sort"
+)
+
+// SamplingParams controls how the next token is chosen from the logits.
+// Penalties are applied to the logits first. The filters are then applied in
+// the order top-k, top-p, typical, min-p, each on the distribution left over
+// by the previous one; at least one token always survives. A zero value
+// disables a penalty or filter.
+type SamplingParams struct {
+	// Temperature scales the logits before the softmax; 0 selects the most
+	// likely token every time (greedy decoding)
+	Temperature float32
+	// TopK keeps only the K most likely tokens
+	TopK int
+	// TopP keeps the smallest set of most likely tokens whose cumulative
+	// probability reaches TopP (nucleus sampling)
+	TopP float32
+	// TypicalP keeps the tokens whose information content is closest to the
+	// entropy of the distribution, up to a cumulative probability of TypicalP
+	TypicalP float32
+	// MinP drops tokens less likely than MinP times the most likely token
+	MinP float32
+
+	// RepetitionPenalty divides the positive logits and multiplies the
+	// negative logits of tokens in the penalty window (CTRL); 1 disables it
+	RepetitionPenalty float32
+	// PresencePenalty is subtracted once from the logit of every token that
+	// occurs in the penalty window
+	PresencePenalty float32
+	// FrequencyPenalty is subtracted from a token's logit once per occurrence
+	// in the penalty window
+	FrequencyPenalty float32
+	// PenaltyWindow is the number of most recent tokens the penalties look
+	// at; 0 looks at the whole sequence
+	PenaltyWindow int
+	// NoRepeatNGram forbids any n-gram of this size from occurring twice,
+	// unless that would forbid every token
+	NoRepeatNGram int
+	// PenalizePrompt makes the penalties and NoRepeatNGram look at the
+	// prompt as well; by default they only see the generated tokens
+	PenalizePrompt bool
+
+	// StopTokens end generation when sampled, typically the tokenizer's
+	// end-of-text token. The stop token is not returned.
+	StopTokens []int
+}
+
+// isStop reports whether token ends generation
+func (p SamplingParams) isStop(token int) bool {
+	for _, stop := range p.StopTokens {
+		if token == stop {
+			return true
+		}
+	}
+	return false
+}
+
+// penalize returns a copy of logits adjusted for the tokens generated so far
+// in history, or logits itself if no penalty is enabled
+func (p SamplingParams) penalize(logits []float32, history []int) []float32 {
+	repetition := p.RepetitionPenalty > 0 && p.RepetitionPenalty != 1
+	if !repetition && p.PresencePenalty == 0 && p.FrequencyPenalty == 0 && p.NoRepeatNGram <= 0 {
+		return logits
+	}
+	logits = append([]float32(nil), logits...)
+
+	window := history
+	if p.PenaltyWindow > 0 && len(window) > p.PenaltyWindow {
+		window = window[len(window)-p.PenaltyWindow:]
+	}
+	counts := make(map[int]int)
+	for _, tok := range window {
+		if tok >= 0 && tok < len(logits) {
+			counts[tok]++
+		}
+	}
+
+	for tok, n := range counts {
+		if repetition {
+			if logits[tok] > 0 {
+				logits[tok] /= p.RepetitionPenalty
+			} else {
+				logits[tok] *= p.RepetitionPenalty
+			}
+		}
+		logits[tok] -= p.PresencePenalty + p.FrequencyPenalty*float32(n)
+	}
+
+	banned := make(map[int]bool)
+	for _, tok := range bannedNGramTokens(history, p.NoRepeatNGram) {
+		if tok >= 0 && tok < len(logits) {
+			banned[tok] = true
+		}
+	}
+	// With every token banned there would be nothing left to sample, so the
+	// n-gram is allowed to repeat instead
+	if len(banned) < len(logits) {
+		for tok := range banned {
+			logits[tok] = float32(math.Inf(-1))
+		}
+	}
+
+	return logits
+}
+
+// bannedNGramTokens returns the tokens that would complete an n-gram already
+// present in history
+func bannedNGramTokens(history []int, n int) []int {
+	if n <= 0 || len(history) < n-1 {
+		return nil
+	}
+	prefix := history[len(history)-(n-1):]
+
+	var banned []int
+	for start := 0; start+n <= len(history); start++ {
+		match := true
+		for i, tok := range prefix {
+			if history[start+i] != tok {
+				match = false
+				break
+			}
+		}
+		if match {
+			banned = append(banned, history[start+n-1])
+		}
+	}
+	return banned
+}
+
+// candidate is a token still eligible for sampling
+type candidate struct {
+	id   int
+	prob float32
+}
+
+// filter applies the enabled truncation strategies to probs and returns the
+// surviving tokens, most likely first
+func (p SamplingParams) filter(probs []float32) []candidate {
+	candidates := make([]candidate, len(probs))
+	for i, prob := range probs {
+		candidates[i] = candidate{id: i, prob: prob}
+	}
+	if p.TopK <= 0 && (p.TopP <= 0 || p.TopP >= 1) && (p.TypicalP <= 0 || p.TypicalP >= 1) && p.MinP <= 0 {
+		// Nothing to truncate, so the order does not matter
+		return candidates
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].prob > candidates[j].prob
+	})
+
+	if p.TopK > 0 && p.TopK < len(candidates) {
+		candidates = candidates[:p.TopK]
+	}
+
+	if p.TopP > 0 && p.TopP < 1 {
+		candidates = keepMass(candidates, p.TopP)
+	}
+
+	if p.TypicalP > 0 && p.TypicalP < 1 {
+		candidates = typical(candidates, p.TypicalP)
+	}
+
+	if p.MinP > 0 {
+		threshold := p.MinP * candidates[0].prob
+		n := 1
+		for n < len(candidates) && candidates[n].prob >= threshold {
+			n++
+		}
+		candidates = candidates[:n]
+	}
+
+	return candidates
+}
+
+// keepMass returns the shortest prefix of candidates holding at least mass of
+// their total probability
+func keepMass(candidates []candidate, mass float32) []candidate {
+	var total float32
+	for _, c := range candidates {
+		total += c.prob
+	}
+
+	cumsum := float32(0)
+	for i, c := range candidates {
+		cumsum += c.prob
+		if cumsum >= mass*total {
+			return candidates[:i+1]
+		}
+	}
+	return candidates
+}
+
+// typical implements locally typical sampling (Meister et al.): tokens are
+// ranked by how far their surprisal is from the entropy of the distribution
+// and kept up to the requested probability mass
+func typical(candidates []candidate, mass float32) []candidate {
+	var total float32
+	for _, c := range candidates {
+		total += c.prob
+	}
+
+	var entropy float64
+	for _, c := range candidates {
+		if p := float64(c.prob / total); p > 0 {
+			entropy -= p * math.Log(p)
+		}
+	}
+
+	type ranked struct {
+		candidate
+		distance float64
+	}
+	byDistance := make([]ranked, len(candidates))
+	for i, c := range candidates {
+		d := math.Inf(1)
+		if p := float64(c.prob / total); p > 0 {
+			d = math.Abs(-math.Log(p) - entropy)
+		}
+		byDistance[i] = ranked{c, d}
+	}
+	sort.SliceStable(byDistance, func(i, j int) bool {
+		return byDistance[i].distance < byDistance[j].distance
+	})
+
+	kept := make([]candidate, len(byDistance))
+	for i, r := range byDistance {
+		kept[i] = r.candidate
+	}
+	kept = keepMass(kept, mass)
+
+	// Restore the most-likely-first order expected by later filters
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].prob > kept[j].prob
+	})
+	return kept
+}
+
+// softmax returns the probabilities of logits scaled by 1/temperature
+func softmax(logits []float32, temperature float32) []float32 {
+	probs := make([]float32, len(logits))
+	maxLogit := float32(math.Inf(-1))
+
+	for i, l := range logits {
+		probs[i] = l / temperature
+		if probs[i] > maxLogit {
+			maxLogit = probs[i]
+		}
+	}
+
+	sum := float32(0)
+	for i := range probs {
+		probs[i] = float32(math.Exp(float64(probs[i] - maxLogit)))
+		sum += probs[i]
+	}
+
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
+}
+
+// argmax returns the index of the largest value
+func argmax(values []float32) int {
+	maxIdx := 0
+	for i, v := range values {
+		if v > values[maxIdx] {
+			maxIdx = i
+		}
+	}
+	return maxIdx
+}
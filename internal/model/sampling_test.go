@@ -0,0 +1,123 @@
+package model
+
+import (
+	"sort"
+	"testing"
+)
+
+func candidateIDs(candidates []candidate) []int {
+	ids := make([]int, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.id
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestSamplingParams_Filter(t *testing.T) {
+	probs := []float32{0.05, 0.4, 0.1, 0.3, 0.15}
+
+	tests := []struct {
+		name   string
+		params SamplingParams
+		want   []int
+	}{
+		{"no filter", SamplingParams{Temperature: 1}, []int{0, 1, 2, 3, 4}},
+		{"top-k", SamplingParams{TopK: 2}, []int{1, 3}},
+		{"top-p", SamplingParams{TopP: 0.8}, []int{1, 3, 4}},
+		{"top-p keeps one", SamplingParams{TopP: 0.01}, []int{1}},
+		{"min-p", SamplingParams{MinP: 0.3}, []int{1, 3, 4}},
+		{"top-k then min-p", SamplingParams{TopK: 3, MinP: 0.5}, []int{1, 3}},
+		{"typical", SamplingParams{TypicalP: 0.25}, []int{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := candidateIDs(tt.params.filter(probs))
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kept %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLMHead_SampleGreedyAndTopK(t *testing.T) {
+	lm := NewLMHead(4, 5, testRand())
+	logits := []float32{0.1, 2.5, -1, 2.4, 0.3}
+
+	if got := lm.Sample(logits, SamplingParams{}, nil); got != 1 {
+		t.Errorf("temperature 0 should be greedy, got token %d", got)
+	}
+
+	rng := testRand()
+	for i := 0; i < 200; i++ {
+		if got := lm.Sample(logits, SamplingParams{Temperature: 5, TopK: 2}, rng); got != 1 && got != 3 {
+			t.Fatalf("top-k 2 sampled token %d outside the two most likely", got)
+		}
+	}
+}
+
+func TestSamplingParams_Penalize(t *testing.T) {
+	logits := []float32{2, -1, 0.5, 3}
+	history := []int{0, 1, 0, 2}
+
+	tests := []struct {
+		name   string
+		params SamplingParams
+		want   []float32
+	}{
+		{"disabled", SamplingParams{}, []float32{2, -1, 0.5, 3}},
+		{"repetition", SamplingParams{RepetitionPenalty: 2}, []float32{1, -2, 0.25, 3}},
+		{"presence", SamplingParams{PresencePenalty: 0.5}, []float32{1.5, -1.5, 0, 3}},
+		{"frequency", SamplingParams{FrequencyPenalty: 0.5}, []float32{1, -1.5, 0, 3}},
+		{"window", SamplingParams{FrequencyPenalty: 0.5, PenaltyWindow: 2}, []float32{1.5, -1, 0, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.params.penalize(logits, history)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("penalize() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if logits[0] != 2 {
+		t.Errorf("penalize modified its input")
+	}
+}
+
+func TestBannedNGramTokens(t *testing.T) {
+	// "5 6" was followed by 7 and by 9 before; the sequence now ends in "5 6"
+	history := []int{5, 6, 7, 1, 5, 6, 9, 5, 6}
+	got := bannedNGramTokens(history, 3)
+	sort.Ints(got)
+	if len(got) != 2 || got[0] != 7 || got[1] != 9 {
+		t.Errorf("bannedNGramTokens() = %v, want [7 9]", got)
+	}
+
+	if got := bannedNGramTokens(history, 0); got != nil {
+		t.Errorf("n = 0 should ban nothing, got %v", got)
+	}
+}
+
+func TestSamplingParams_PenalizeKeepsATokenUnbanned(t *testing.T) {
+	// Both tokens of the vocabulary followed "0 1" before
+	history := []int{0, 1, 0, 0, 1, 1, 0, 1}
+	params := SamplingParams{NoRepeatNGram: 3}
+
+	got := params.penalize([]float32{1, 2}, history)
+	if got[0] != 1 || got[1] != 2 {
+		t.Errorf("penalize() = %v, want the logits unchanged", got)
+	}
+	if tok := NewLMHead(1, 2, testRand()).Sample(got, params, testRand()); tok != 0 && tok != 1 {
+		t.Errorf("Sample() = %d after banning every token", tok)
+	}
+}
@@ -1,27 +1,34 @@
 package model
 
 import (
+	"bufio"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"unsafe"
 )
 
-// ModelState represents the complete state of a GPT2 model
+// ModelState represents the complete state of a GPT2 model as stored in
+// version 1 checkpoints
 type ModelState struct {
 	Config Config `json:"config"`
-	
+
 	// Embeddings
-	TokenEmbeddings  [][]float32 `json:"token_embeddings"`
+	TokenEmbeddings    [][]float32 `json:"token_embeddings"`
 	PositionEmbeddings [][]float32 `json:"position_embeddings"`
-	
+
 	// Transformer layers
 	Layers []TransformerLayerState `json:"layers"`
-	
-	// Final normalization
+
+	// Final normalization; RMSNorm has no beta
 	FinalNormGamma []float32 `json:"final_norm_gamma"`
-	FinalNormBeta  []float32 `json:"final_norm_beta"`
-	
+	FinalNormBeta  []float32 `json:"final_norm_beta,omitempty"`
+
 	// Language model head
 	LMHeadWeight [][]float32 `json:"lm_head_weight"`
 	LMHeadBias   []float32   `json:"lm_head_bias"`
@@ -34,78 +41,148 @@ type TransformerLayerState struct {
 	QKVProjBias   []float32   `json:"qkv_proj_bias"`
 	OutProjWeight [][]float32 `json:"out_proj_weight"`
 	OutProjBias   []float32   `json:"out_proj_bias"`
-	
-	// Layer normalization 1
+
+	// Normalization 1; RMSNorm has no beta
 	Norm1Gamma []float32 `json:"norm1_gamma"`
-	Norm1Beta  []float32 `json:"norm1_beta"`
-	
+	Norm1Beta  []float32 `json:"norm1_beta,omitempty"`
+
 	// Feed forward
 	FF1Weight [][]float32 `json:"ff1_weight"`
 	FF1Bias   []float32   `json:"ff1_bias"`
 	FF2Weight [][]float32 `json:"ff2_weight"`
 	FF2Bias   []float32   `json:"ff2_bias"`
-	
-	// Layer normalization 2
+
+	// Normalization 2; RMSNorm has no beta
 	Norm2Gamma []float32 `json:"norm2_gamma"`
-	Norm2Beta  []float32 `json:"norm2_beta"`
+	Norm2Beta  []float32 `json:"norm2_beta,omitempty"`
+
+	// NormType of both normalizations; empty means NormLayerNorm
+	NormType string `json:"norm_type,omitempty"`
 }
 
-// Save saves model weights to a file
+// Checkpoint files start with the magic number and a format version. Version
+// 1 is followed by a JSON-encoded ModelState. Version 2 is followed by
+//
+//	uint32              length of the header in bytes
+//	header              JSON-encoded checkpointHeader
+//	padding             zero bytes up to the next multiple of dataAlignment
+//	data                every tensor as raw little-endian float32, row-major
+//
+// so the weights can be mapped into memory instead of being parsed.
+const (
+	checkpointMagic   = 0x476F4C4D // "GoLM" in hex
+	checkpointVersion = 2
+
+	dataAlignment = 64
+)
+
+// checkpointHeader indexes the tensors of a version 2 checkpoint
+type checkpointHeader struct {
+	Config  Config        `json:"config"`
+	Tensors []tensorEntry `json:"tensors"`
+}
+
+// tensorEntry locates one tensor, named as in Parameters, in the data section
+type tensorEntry struct {
+	Name   string `json:"name"`
+	Shape  []int  `json:"shape"`
+	Offset int64  `json:"offset"` // in bytes from the start of the data section
+}
+
+// Save saves model weights to a file in the version 2 format. The file is
+// written next to path and renamed into place, so a checkpoint that is still
+// mapped by Load can safely be overwritten.
 func (g *GPT2) Save(path string) error {
-	state := &ModelState{
-		Config: g.config,
-		TokenEmbeddings: g.embeddings.TokenEmbed,
-		PositionEmbeddings: g.embeddings.PositionEmbed,
-		Layers: make([]TransformerLayerState, len(g.layers)),
-		FinalNormGamma: g.finalNorm.Gamma,
-		FinalNormBeta: g.finalNorm.Beta,
-		LMHeadWeight: g.lmHead.linear.Weight,
-		LMHeadBias: g.lmHead.linear.Bias,
-	}
-
-	// Save transformer layer states
-	for i, layer := range g.layers {
-		state.Layers[i] = TransformerLayerState{
-			QKVProjWeight: layer.Attention.QKVProj.Weight,
-			QKVProjBias:   layer.Attention.QKVProj.Bias,
-			OutProjWeight: layer.Attention.OutProj.Weight,
-			OutProjBias:   layer.Attention.OutProj.Bias,
-			Norm1Gamma:    layer.Norm1.Gamma,
-			Norm1Beta:     layer.Norm1.Beta,
-			FF1Weight:     layer.FFN.fc1.Weight,
-			FF1Bias:       layer.FFN.fc1.Bias,
-			FF2Weight:     layer.FFN.fc2.Weight,
-			FF2Bias:       layer.FFN.fc2.Bias,
-			Norm2Gamma:    layer.Norm2.Gamma,
-			Norm2Beta:     layer.Norm2.Beta,
-		}
-	}
-
-	// Create file
-	f, err := os.Create(path)
+	params := g.Parameters()
+	header := checkpointHeader{Config: g.config}
+	var offset int64
+	for _, p := range params {
+		header.Tensors = append(header.Tensors, tensorEntry{
+			Name:   p.Name,
+			Shape:  p.Value.Shape,
+			Offset: offset,
+		})
+		offset += int64(p.Size()) * 4
+	}
+
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode header: %v", err)
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		// Write magic number, version and header
+		prefix := []uint32{checkpointMagic, checkpointVersion, uint32(len(headerData))}
+		if err := binary.Write(w, binary.LittleEndian, prefix); err != nil {
+			return fmt.Errorf("failed to write file header: %v", err)
+		}
+		if _, err := w.Write(headerData); err != nil {
+			return fmt.Errorf("failed to write file header: %v", err)
+		}
+		if _, err := w.Write(make([]byte, padding(dataOffset(len(headerData))))); err != nil {
+			return fmt.Errorf("failed to write file header: %v", err)
+		}
+
+		return writeTensors(w, params)
+	})
+}
+
+// writeTensors writes the values of params as little-endian float32
+func writeTensors(w io.Writer, params []*Parameter) error {
+	for _, p := range params {
+		if err := binary.Write(w, binary.LittleEndian, p.Value.Values()); err != nil {
+			return fmt.Errorf("failed to write %s: %v", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file next to path through write and renames it
+// into place once complete
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
-	// Write magic number and version
-	if err := binary.Write(f, binary.LittleEndian, uint32(0x476F4C4D)); err != nil { // "GoLM" in hex
-		return fmt.Errorf("failed to write magic number: %v", err)
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		return err
 	}
-	if err := binary.Write(f, binary.LittleEndian, uint32(1)); err != nil { // version 1
-		return fmt.Errorf("failed to write version: %v", err)
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
 	}
-
-	// Encode and write model state
-	encoder := json.NewEncoder(f)
-	if err := encoder.Encode(state); err != nil {
-		return fmt.Errorf("failed to encode model state: %v", err)
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set file mode: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename file: %v", err)
 	}
-
 	return nil
 }
 
-// Load loads model weights from a file
+// dataOffset returns the unpadded position of the data section for a header
+// of headerLen bytes
+func dataOffset(headerLen int) int {
+	return 12 + headerLen
+}
+
+// padding returns the number of zero bytes that align offset to dataAlignment
+func padding(offset int) int {
+	return (dataAlignment - offset%dataAlignment) % dataAlignment
+}
+
+// Load loads model weights from a file written in either format version.
+// Version 2 files are memory-mapped where the platform allows it: the
+// weights then refer to the mapping directly and are paged in from disk on
+// first use. The mapping is private, so later updates to the weights never
+// reach the file. It is released by the next Load or LoadSafetensors and by
+// Close.
 func (g *GPT2) Load(path string) error {
 	// Open file
 	f, err := os.Open(path)
@@ -119,7 +196,7 @@ func (g *GPT2) Load(path string) error {
 	if err := binary.Read(f, binary.LittleEndian, &magic); err != nil {
 		return fmt.Errorf("failed to read magic number: %v", err)
 	}
-	if magic != 0x476F4C4D {
+	if magic != checkpointMagic {
 		return fmt.Errorf("invalid model file format")
 	}
 
@@ -128,59 +205,338 @@ func (g *GPT2) Load(path string) error {
 	if err := binary.Read(f, binary.LittleEndian, &version); err != nil {
 		return fmt.Errorf("failed to read version: %v", err)
 	}
-	if version != 1 {
-		return fmt.Errorf("unsupported model version: %d", version)
+	switch version {
+	case 1:
+		return g.loadV1(f)
+	case 2:
+		return g.loadV2(f)
+	}
+	return fmt.Errorf("unsupported model version: %d", version)
+}
+
+// matchConfig checks that the config saved with some weights describes a
+// model of the same shape. Settings that leave the shapes alone, like the
+// block layout, are taken over from the saved config, so weights always run
+// the way they were trained.
+func (g *GPT2) matchConfig(saved Config) error {
+	saved = saved.withDefaults()
+	if err := saved.Validate(); err != nil {
+		return err
+	}
+	layout := g.config
+	layout.NormPosition = saved.NormPosition
+	if saved != layout {
+		return fmt.Errorf("model configuration mismatch")
+	}
+	g.setNormPosition(saved.NormPosition)
+	return nil
+}
+
+// ReadConfig returns the model config recorded in a checkpoint or a
+// safetensors file written by SaveSafetensors, without loading the weights
+func ReadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	var prefix [2]uint32
+	if err := binary.Read(f, binary.LittleEndian, &prefix); err != nil {
+		return Config{}, fmt.Errorf("failed to read file header: %v", err)
+	}
+
+	if prefix[0] != checkpointMagic {
+		// Not a checkpoint; safetensors files start with the header length
+		data, err := mapFile(f)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to map file: %v", err)
+		}
+		_, metadata, err := parseSafetensorsHeader(data)
+		unmapFile(data)
+		if err != nil {
+			return Config{}, err
+		}
+		return configFromMetadata(metadata)
+	}
+
+	switch prefix[1] {
+	case 1:
+		var state struct {
+			Config Config `json:"config"`
+		}
+		if err := json.NewDecoder(f).Decode(&state); err != nil {
+			return Config{}, fmt.Errorf("failed to decode model state: %v", err)
+		}
+		return state.Config.withDefaults(), nil
+	case 2:
+		header, _, err := readHeaderV2(f)
+		return header.Config.withDefaults(), err
+	}
+	return Config{}, fmt.Errorf("unsupported model version: %d", prefix[1])
+}
+
+// readHeaderV2 reads the header following the version and returns it with
+// its length in bytes
+func readHeaderV2(r io.Reader) (checkpointHeader, int, error) {
+	var header checkpointHeader
+	var headerLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &headerLen); err != nil {
+		return header, 0, fmt.Errorf("failed to read header length: %v", err)
+	}
+	headerData := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, headerData); err != nil {
+		return header, 0, fmt.Errorf("failed to read header: %v", err)
+	}
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return header, 0, fmt.Errorf("failed to decode header: %v", err)
 	}
+	return header, int(headerLen), nil
+}
 
+// loadV2 reads the header following the version and binds every parameter to
+// its data
+func (g *GPT2) loadV2(f *os.File) error {
+	header, headerLen, err := readHeaderV2(f)
+	if err != nil {
+		return err
+	}
+
+	if err := g.matchConfig(header.Config); err != nil {
+		return err
+	}
+
+	mapping, err := mapFile(f)
+	if err != nil {
+		return fmt.Errorf("failed to map file: %v", err)
+	}
+	if err := g.bindV2(header, headerLen, mapping); err != nil {
+		unmapFile(mapping)
+		return err
+	}
+	return g.replaceMapping(mapping)
+}
+
+// bindV2 binds every parameter to its data in data, the whole checkpoint
+func (g *GPT2) bindV2(header checkpointHeader, headerLen int, data []byte) error {
+	start := dataOffset(headerLen)
+	start += padding(start)
+	if start > len(data) {
+		return fmt.Errorf("file truncated")
+	}
+	data = data[start:]
+
+	tensors := make(map[string]rawTensor, len(header.Tensors))
+	for _, e := range header.Tensors {
+		end := e.Offset + int64(shapeSize(e.Shape))*4
+		if e.Offset < 0 || end > int64(len(data)) {
+			return fmt.Errorf("failed to load %s: data out of range", e.Name)
+		}
+		tensors[e.Name] = rawTensor{Dtype: "F32", Shape: e.Shape, Data: data[e.Offset:end]}
+	}
+
+	return g.bindTensors(tensors)
+}
+
+// rawTensor is a stored tensor; Data holds little-endian float32 values when
+// Dtype is "F32" and is empty otherwise
+type rawTensor struct {
+	Dtype string
+	Shape []int
+	Data  []byte
+}
+
+// values returns the tensor's data after checking it is of type F32
+func (t rawTensor) values() ([]float32, error) {
+	if t.Dtype != "F32" {
+		return nil, fmt.Errorf("unsupported dtype %s", t.Dtype)
+	}
+	return float32s(t.Data), nil
+}
+
+// bindTensors points every parameter at the stored tensor of the same name.
+// Only the Data of each tensor is replaced, so parameters handed out
+// earlier, e.g. to an optimizer, stay valid. Nothing is replaced unless
+// every tensor fits.
+func (g *GPT2) bindTensors(tensors map[string]rawTensor) error {
+	params := g.Parameters()
+	values := make([][]float32, len(params))
+	for i, p := range params {
+		t, ok := tensors[p.Name]
+		if !ok {
+			return fmt.Errorf("failed to load %s: missing from checkpoint", p.Name)
+		}
+		if !slices.Equal(t.Shape, p.Value.Shape) {
+			return fmt.Errorf("failed to load %s: expected shape %v, got %v", p.Name, p.Value.Shape, t.Shape)
+		}
+		v, err := t.values()
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", p.Name, err)
+		}
+		values[i] = v
+	}
+	for i, p := range params {
+		p.Value.Data = values[i]
+	}
+	return nil
+}
+
+// replaceMapping records mapping, now holding the weights, as the model's
+// mapping and releases the one it replaces
+func (g *GPT2) replaceMapping(mapping []byte) error {
+	old := g.mapping
+	g.mapping = mapping
+	if err := unmapFile(old); err != nil {
+		return fmt.Errorf("failed to unmap previous weights: %v", err)
+	}
+	return nil
+}
+
+// Close releases the file mapped by Load or LoadSafetensors. The model must
+// not be used afterwards.
+func (g *GPT2) Close() error {
+	return g.replaceMapping(nil)
+}
+
+// float32s interprets b as little-endian float32 values, without copying when
+// the host byte order and the alignment of b allow it
+func float32s(b []byte) []float32 {
+	n := len(b) / 4
+	if n == 0 {
+		return nil
+	}
+	if nativeLittleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*float32)(unsafe.Pointer(&b[0])), n)
+	}
+	values := make([]float32, n)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return values
+}
+
+var nativeLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// loadV1 decodes the JSON model state following the version
+func (g *GPT2) loadV1(r io.Reader) error {
 	// Decode model state
 	var state ModelState
-	decoder := json.NewDecoder(f)
+	decoder := json.NewDecoder(r)
 	if err := decoder.Decode(&state); err != nil {
 		return fmt.Errorf("failed to decode model state: %v", err)
 	}
 
-	// Verify config matches
-	if state.Config != g.config {
-		return fmt.Errorf("model configuration mismatch")
+	if err := g.matchConfig(state.Config); err != nil {
+		return err
 	}
 
-	// Load embeddings
-	g.embeddings.TokenEmbed = state.TokenEmbeddings
-	g.embeddings.PositionEmbed = state.PositionEmbeddings
-
-	// Load transformer layers
+	// Weights are copied into the existing tensors so that parameters handed
+	// out earlier, e.g. to an optimizer, stay valid
 	if len(state.Layers) != len(g.layers) {
 		return fmt.Errorf("layer count mismatch")
 	}
+
+	// Load embeddings
+	targets := []loadTarget{
+		{name: "token_embeddings", dst: g.embeddings.TokenEmbed, matrix: state.TokenEmbeddings},
+	}
+	if g.embeddings.PositionEmbed != nil {
+		targets = append(targets, loadTarget{name: "position_embeddings", dst: g.embeddings.PositionEmbed, matrix: state.PositionEmbeddings})
+	}
+
+	// Load transformer layers
 	for i, layerState := range state.Layers {
 		layer := g.layers[i]
-		
-		// Load attention weights
-		layer.Attention.QKVProj.Weight = layerState.QKVProjWeight
-		layer.Attention.QKVProj.Bias = layerState.QKVProjBias
-		layer.Attention.OutProj.Weight = layerState.OutProjWeight
-		layer.Attention.OutProj.Bias = layerState.OutProjBias
-		
-		// Load layer norms
-		layer.Norm1.Gamma = layerState.Norm1Gamma
-		layer.Norm1.Beta = layerState.Norm1Beta
-		layer.Norm2.Gamma = layerState.Norm2Gamma
-		layer.Norm2.Beta = layerState.Norm2Beta
-		
-		// Load feedforward weights
-		layer.FFN.fc1.Weight = layerState.FF1Weight
-		layer.FFN.fc1.Bias = layerState.FF1Bias
-		layer.FFN.fc2.Weight = layerState.FF2Weight
-		layer.FFN.fc2.Bias = layerState.FF2Bias
-	}
-
-	// Load final normalization
-	g.finalNorm.Gamma = state.FinalNormGamma
-	g.finalNorm.Beta = state.FinalNormBeta
-
-	// Load language model head
-	g.lmHead.linear.Weight = state.LMHeadWeight
-	g.lmHead.linear.Bias = state.LMHeadBias
+		prefix := fmt.Sprintf("layers.%d.", i)
+		normType := layerState.NormType
+		if normType == "" {
+			normType = NormLayerNorm
+		}
+		if normType != g.config.NormType {
+			return fmt.Errorf("layer %d uses %s, the model %s", i, normType, g.config.NormType)
+		}
+		targets = append(targets, []loadTarget{
+			// Attention weights
+			{name: prefix + "qkv_proj_weight", dst: layer.Attention.QKVProj.Weight, matrix: layerState.QKVProjWeight},
+			{name: prefix + "qkv_proj_bias", dst: layer.Attention.QKVProj.Bias, vector: layerState.QKVProjBias},
+			{name: prefix + "out_proj_weight", dst: layer.Attention.OutProj.Weight, matrix: layerState.OutProjWeight},
+			{name: prefix + "out_proj_bias", dst: layer.Attention.OutProj.Bias, vector: layerState.OutProjBias},
+
+			// Feedforward weights
+			{name: prefix + "ff1_weight", dst: layer.FFN.fc1.Weight, matrix: layerState.FF1Weight},
+			{name: prefix + "ff1_bias", dst: layer.FFN.fc1.Bias, vector: layerState.FF1Bias},
+			{name: prefix + "ff2_weight", dst: layer.FFN.fc2.Weight, matrix: layerState.FF2Weight},
+			{name: prefix + "ff2_bias", dst: layer.FFN.fc2.Bias, vector: layerState.FF2Bias},
+		}...)
+
+		// Normalizations
+		targets = append(targets, normTargets(prefix+"norm1", layer.Norm1, layerState.Norm1Gamma, layerState.Norm1Beta)...)
+		targets = append(targets, normTargets(prefix+"norm2", layer.Norm2, layerState.Norm2Gamma, layerState.Norm2Beta)...)
+	}
+
+	// Final normalization and language model head
+	targets = append(targets, normTargets("final_norm", g.finalNorm, state.FinalNormGamma, state.FinalNormBeta)...)
+	targets = append(targets, []loadTarget{
+		{name: "lm_head_weight", dst: g.lmHead.linear.Weight, matrix: state.LMHeadWeight},
+		{name: "lm_head_bias", dst: g.lmHead.linear.Bias, vector: state.LMHeadBias},
+	}...)
+
+	for _, t := range targets {
+		var err error
+		if len(t.dst.Shape) == 2 {
+			err = loadMatrix(t.dst, t.matrix)
+		} else {
+			err = loadVector(t.dst, t.vector)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", t.name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadTarget pairs a model tensor with the decoded values destined for it
+type loadTarget struct {
+	name   string
+	dst    *Tensor
+	matrix [][]float32
+	vector []float32
+}
+
+// normTargets returns the load targets of the parameters of norm
+func normTargets(name string, norm Normalizer, gamma, beta []float32) []loadTarget {
+	switch norm := norm.(type) {
+	case *LayerNorm:
+		return []loadTarget{
+			{name: name + "_gamma", dst: norm.Gamma, vector: gamma},
+			{name: name + "_beta", dst: norm.Beta, vector: beta},
+		}
+	case *RMSNorm:
+		return []loadTarget{{name: name + "_gamma", dst: norm.Gamma, vector: gamma}}
+	}
+	panic(fmt.Sprintf("unsupported normalizer %T", norm))
+}
 
+// loadMatrix copies rows into dst after checking they match its shape
+func loadMatrix(dst *Tensor, rows [][]float32) error {
+	if len(rows) != dst.Rows() {
+		return fmt.Errorf("expected %d rows, got %d", dst.Rows(), len(rows))
+	}
+	for i, row := range rows {
+		if len(row) != dst.Cols() {
+			return fmt.Errorf("row %d: expected %d values, got %d", i, dst.Cols(), len(row))
+		}
+		copy(dst.Row(i), row)
+	}
+	return nil
+}
+
+// loadVector copies values into the one-dimensional tensor dst
+func loadVector(dst *Tensor, values []float32) error {
+	if len(values) != dst.Size() {
+		return fmt.Errorf("expected %d values, got %d", dst.Size(), len(values))
+	}
+	copy(dst.Values(), values)
 	return nil
 }
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// assertSameWeights fails unless a and b hold bit-identical parameters
+func assertSameWeights(t *testing.T, a, b *GPT2) {
+	t.Helper()
+	pa, pb := a.Parameters(), b.Parameters()
+	for i := range pa {
+		va, vb := pa[i].Value.Values(), pb[i].Value.Values()
+		for j := range va {
+			if va[j] != vb[j] {
+				t.Fatalf("%s[%d] = %v, want %v", pb[i].Name, j, vb[j], va[j])
+			}
+		}
+	}
+}
+
+func TestGPT2_SaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.pt")
+	g := NewGPT2(tinyConfig(), testRand())
+	if err := g.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded := NewGPT2(tinyConfig(), rand.New(rand.NewSource(1000)))
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+
+	// Updating the loaded weights must neither fail nor reach the file, and
+	// saving over the mapped file must not disturb the loaded model
+	before, _ := os.ReadFile(path)
+	loaded.Parameters()[0].Value.Data[0] += 1
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(before, after) {
+		t.Errorf("updating a loaded weight modified the checkpoint")
+	}
+	if err := loaded.Save(path); err != nil {
+		t.Fatalf("Save() over a loaded checkpoint error = %v", err)
+	}
+	if got := loaded.Parameters()[0].Value.Data[0]; got != g.Parameters()[0].Value.Data[0]+1 {
+		t.Errorf("loaded weight changed after saving over its file: %v", got)
+	}
+}
+
+func TestGPT2_LoadVersion1(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	state := &ModelState{
+		Config:             g.config,
+		TokenEmbeddings:    g.embeddings.TokenEmbed.ToRows(),
+		PositionEmbeddings: g.embeddings.PositionEmbed.ToRows(),
+		FinalNormGamma:     g.finalNorm.(*LayerNorm).Gamma.Values(),
+		FinalNormBeta:      g.finalNorm.(*LayerNorm).Beta.Values(),
+		LMHeadWeight:       g.lmHead.linear.Weight.ToRows(),
+		LMHeadBias:         g.lmHead.linear.Bias.Values(),
+	}
+	for _, layer := range g.layers {
+		norm1, norm2 := layer.Norm1.(*LayerNorm), layer.Norm2.(*LayerNorm)
+		state.Layers = append(state.Layers, TransformerLayerState{
+			QKVProjWeight: layer.Attention.QKVProj.Weight.ToRows(),
+			QKVProjBias:   layer.Attention.QKVProj.Bias.Values(),
+			OutProjWeight: layer.Attention.OutProj.Weight.ToRows(),
+			OutProjBias:   layer.Attention.OutProj.Bias.Values(),
+			Norm1Gamma:    norm1.Gamma.Values(),
+			Norm1Beta:     norm1.Beta.Values(),
+			FF1Weight:     layer.FFN.fc1.Weight.ToRows(),
+			FF1Bias:       layer.FFN.fc1.Bias.Values(),
+			FF2Weight:     layer.FFN.fc2.Weight.ToRows(),
+			FF2Bias:       layer.FFN.fc2.Bias.Values(),
+			Norm2Gamma:    norm2.Gamma.Values(),
+			Norm2Beta:     norm2.Beta.Values(),
+			NormType:      NormLayerNorm,
+		})
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{checkpointMagic, 1})
+	if err := json.NewEncoder(&buf).Encode(state); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "v1.pt")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewGPT2(tinyConfig(), rand.New(rand.NewSource(1000)))
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+
+	state.Layers[0].NormType = NormRMSNorm
+	buf.Reset()
+	binary.Write(&buf, binary.LittleEndian, []uint32{checkpointMagic, 1})
+	if err := json.NewEncoder(&buf).Encode(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(path); err == nil {
+		t.Errorf("Load() of an RMSNorm layer into a LayerNorm model succeeded")
+	}
+}
+
+func TestGPT2_LoadRestoresNormPosition(t *testing.T) {
+	cfg := tinyConfig()
+	cfg.NormPosition = NormPre
+	g := NewGPT2(cfg, testRand())
+	path := filepath.Join(t.TempDir(), "model.pt")
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewGPT2(tinyConfig(), rand.New(rand.NewSource(1000)))
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := loaded.Config().NormPosition; got != NormPre {
+		t.Errorf("NormPosition = %q, want %q", got, NormPre)
+	}
+	for i, layer := range loaded.layers {
+		if !layer.PreNorm {
+			t.Errorf("layer %d is not pre-LN", i)
+		}
+	}
+
+	tokens := []int{1, 2, 3, 4}
+	want := mustForward(t, g, tokens).Values()
+	got := mustForward(t, loaded, tokens).Values()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("logits[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGPT2_LoadRejectsOtherConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.pt")
+	if err := NewGPT2(tinyConfig(), testRand()).Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := tinyConfig()
+	cfg.NumLayers = 1
+	if err := NewGPT2(cfg, testRand()).Load(path); err == nil {
+		t.Errorf("Load() into a model with a different config succeeded")
+	}
+}
+
+func TestGPT2_LoadReleasesReplacedMapping(t *testing.T) {
+	g := NewGPT2(tinyConfig(), testRand())
+	path := filepath.Join(t.TempDir(), "model.pt")
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewGPT2(tinyConfig(), rand.New(rand.NewSource(1000)))
+	for i := 0; i < 2; i++ {
+		if err := loaded.Load(path); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+	}
+	// The weights point into the second mapping only, the first one is gone
+	assertSameWeights(t, g, loaded)
+
+	if err := loaded.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if loaded.mapping != nil {
+		t.Errorf("Close() kept the mapping")
+	}
+}
+
+func TestGPT2_SaveLoadWithoutLearnedPositions(t *testing.T) {
+	cfg := tinyConfig()
+	cfg.PositionEncoding = PositionRoPE
+	g := NewGPT2(cfg, testRand())
+
+	dir := t.TempDir()
+	checkpoint, safetensors := filepath.Join(dir, "model.pt"), filepath.Join(dir, "model.safetensors")
+	if err := g.Save(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SaveSafetensors(safetensors); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewGPT2(cfg, rand.New(rand.NewSource(1000)))
+	if err := loaded.Load(checkpoint); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+	if err := loaded.LoadSafetensors(safetensors); err != nil {
+		t.Fatalf("LoadSafetensors() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+
+	if err := NewGPT2(tinyConfig(), testRand()).Load(checkpoint); err == nil {
+		t.Errorf("Load() of a RoPE checkpoint into a model with learned positions succeeded")
+	}
+	if got, err := ReadConfig(safetensors); err != nil || got != loaded.Config() {
+		t.Errorf("ReadConfig() = %+v, %v, want %+v", got, err, loaded.Config())
+	}
+}
+
+func TestGPT2_CheckpointRecordsFeedForward(t *testing.T) {
+	cfg := tinyConfig()
+	cfg.Activation = ActivationSwiGLU
+	cfg.FFNHiddenDim = 12
+	g := NewGPT2(cfg, testRand())
+	if got := g.layers[0].FFN.fc1.Weight.Shape; !reflect.DeepEqual(got, []int{24, 8}) {
+		t.Fatalf("gated ff1 weight shape = %v, want [24 8]", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "model.pt")
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewGPT2(saved, rand.New(rand.NewSource(1000)))
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	assertSameWeights(t, g, loaded)
+
+	if err := NewGPT2(tinyConfig(), testRand()).Load(path); err == nil {
+		t.Errorf("Load() of a SwiGLU checkpoint into a GELU model succeeded")
+	}
+}
@@ -0,0 +1,209 @@
+package model
+
+import "fmt"
+
+// Tensor is a dense float32 array kept in a single backing slice, addressed
+// through its shape and row-major strides. Slicing and reshaping return views
+// that share the backing slice instead of copying it.
+type Tensor struct {
+	Data    []float32
+	Shape   []int
+	Strides []int
+}
+
+// NewTensor allocates a zeroed, contiguous tensor of the given shape
+func NewTensor(shape ...int) *Tensor {
+	return TensorFrom(make([]float32, shapeSize(shape)), shape...)
+}
+
+// TensorFrom wraps data, laid out row-major, as a tensor of the given shape
+func TensorFrom(data []float32, shape ...int) *Tensor {
+	if len(data) < shapeSize(shape) {
+		panic(fmt.Sprintf("tensor data of length %d is too small for shape %v", len(data), shape))
+	}
+	return &Tensor{
+		Data:    data,
+		Shape:   append([]int(nil), shape...),
+		Strides: rowMajorStrides(shape),
+	}
+}
+
+func shapeSize(shape []int) int {
+	n := 1
+	for _, d := range shape {
+		n *= d
+	}
+	return n
+}
+
+func rowMajorStrides(shape []int) []int {
+	strides := make([]int, len(shape))
+	stride := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= shape[i]
+	}
+	return strides
+}
+
+// Size returns the number of elements
+func (t *Tensor) Size() int {
+	return shapeSize(t.Shape)
+}
+
+// Rows returns the size of the first dimension
+func (t *Tensor) Rows() int {
+	return t.Shape[0]
+}
+
+// Cols returns the size of the last dimension
+func (t *Tensor) Cols() int {
+	return t.Shape[len(t.Shape)-1]
+}
+
+// SameShape reports whether t and o have identical shapes
+func (t *Tensor) SameShape(o *Tensor) bool {
+	if len(t.Shape) != len(o.Shape) {
+		return false
+	}
+	for i := range t.Shape {
+		if t.Shape[i] != o.Shape[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsContiguous reports whether the elements are laid out row-major without gaps
+func (t *Tensor) IsContiguous() bool {
+	stride := 1
+	for i := len(t.Shape) - 1; i >= 0; i-- {
+		if t.Shape[i] != 1 && t.Strides[i] != stride {
+			return false
+		}
+		stride *= t.Shape[i]
+	}
+	return true
+}
+
+func (t *Tensor) offset(idx []int) int {
+	if len(idx) != len(t.Shape) {
+		panic(fmt.Sprintf("index %v does not match tensor shape %v", idx, t.Shape))
+	}
+	off := 0
+	for i, x := range idx {
+		if x < 0 || x >= t.Shape[i] {
+			panic(fmt.Sprintf("index %v out of range for shape %v", idx, t.Shape))
+		}
+		off += x * t.Strides[i]
+	}
+	return off
+}
+
+// At returns the element at idx
+func (t *Tensor) At(idx ...int) float32 {
+	return t.Data[t.offset(idx)]
+}
+
+// Set stores v at idx
+func (t *Tensor) Set(v float32, idx ...int) {
+	t.Data[t.offset(idx)] = v
+}
+
+// Row returns row i of a 2-D tensor whose last dimension is contiguous. The
+// returned slice aliases the tensor's data.
+func (t *Tensor) Row(i int) []float32 {
+	start := i * t.Strides[0]
+	return t.Data[start : start+t.Shape[1] : start+t.Shape[1]]
+}
+
+// Slice returns a view of the elements start..end-1 along dim
+func (t *Tensor) Slice(dim, start, end int) *Tensor {
+	if start < 0 || end > t.Shape[dim] || start > end {
+		panic(fmt.Sprintf("slice [%d:%d] out of range for dimension %d of shape %v", start, end, dim, t.Shape))
+	}
+	shape := append([]int(nil), t.Shape...)
+	shape[dim] = end - start
+
+	data := t.Data[start*t.Strides[dim]:]
+	if shapeSize(shape) == 0 {
+		data = data[:0]
+	}
+	return &Tensor{
+		Data:    data,
+		Shape:   shape,
+		Strides: append([]int(nil), t.Strides...),
+	}
+}
+
+// Reshape returns a view of a contiguous tensor with a new shape of the same size
+func (t *Tensor) Reshape(shape ...int) *Tensor {
+	if shapeSize(shape) != t.Size() {
+		panic(fmt.Sprintf("cannot reshape %v into %v", t.Shape, shape))
+	}
+	if !t.IsContiguous() {
+		panic(fmt.Sprintf("cannot reshape non-contiguous tensor of shape %v", t.Shape))
+	}
+	return TensorFrom(t.Data, shape...)
+}
+
+// Transpose returns a view of a 2-D tensor with its dimensions swapped
+func (t *Tensor) Transpose() *Tensor {
+	return &Tensor{
+		Data:    t.Data,
+		Shape:   []int{t.Shape[1], t.Shape[0]},
+		Strides: []int{t.Strides[1], t.Strides[0]},
+	}
+}
+
+// Contiguous returns t itself if it is contiguous and a compacted copy otherwise
+func (t *Tensor) Contiguous() *Tensor {
+	if t.IsContiguous() {
+		return t
+	}
+	return t.Clone()
+}
+
+// Clone returns a contiguous copy of t
+func (t *Tensor) Clone() *Tensor {
+	c := NewTensor(t.Shape...)
+	if t.IsContiguous() {
+		copy(c.Data, t.Data[:c.Size()])
+		return c
+	}
+
+	idx := make([]int, len(t.Shape))
+	for i := range c.Data {
+		c.Data[i] = t.Data[t.offset(idx)]
+		for d := len(idx) - 1; d >= 0; d-- {
+			idx[d]++
+			if idx[d] < t.Shape[d] {
+				break
+			}
+			idx[d] = 0
+		}
+	}
+	return c
+}
+
+// Values returns the elements of a contiguous tensor as a flat slice
+func (t *Tensor) Values() []float32 {
+	if !t.IsContiguous() {
+		panic(fmt.Sprintf("tensor of shape %v is not contiguous", t.Shape))
+	}
+	return t.Data[:t.Size()]
+}
+
+// ToRows returns the rows of a 2-D tensor as a nested slice of views
+func (t *Tensor) ToRows() [][]float32 {
+	rows := make([][]float32, t.Shape[0])
+	for i := range rows {
+		rows[i] = t.Row(i)
+	}
+	return rows
+}
+
+// Zero sets every element of a contiguous tensor to zero
+func (t *Tensor) Zero() {
+	clear(t.Values())
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTensor_ViewsShareData(t *testing.T) {
+	x := TensorFrom([]float32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, 3, 4)
+
+	cols := x.Slice(1, 1, 3)
+	if cols.IsContiguous() {
+		t.Errorf("column slice should not be contiguous")
+	}
+	if got := cols.Row(2); !reflect.DeepEqual(got, []float32{9, 10}) {
+		t.Errorf("cols.Row(2) = %v, want [9 10]", got)
+	}
+
+	cols.Set(-1, 0, 0)
+	if x.At(0, 1) != -1 {
+		t.Errorf("writing through a view did not reach the parent tensor")
+	}
+
+	rows := x.Slice(0, 1, 3)
+	if !rows.IsContiguous() || rows.At(0, 0) != 4 {
+		t.Errorf("row slice = %v, want contiguous view starting at 4", rows.Values())
+	}
+}
+
+func TestTensor_CloneAndTranspose(t *testing.T) {
+	x := TensorFrom([]float32{1, 2, 3, 4, 5, 6}, 2, 3)
+
+	xt := x.Transpose()
+	if xt.At(2, 1) != 6 || xt.At(0, 1) != 4 {
+		t.Errorf("transpose view has wrong elements")
+	}
+
+	c := xt.Clone()
+	if !c.IsContiguous() || !reflect.DeepEqual(c.Data, []float32{1, 4, 2, 5, 3, 6}) {
+		t.Errorf("Clone() = %v, want [1 4 2 5 3 6]", c.Data)
+	}
+
+	r := x.Reshape(3, 2)
+	if r.At(2, 0) != 5 {
+		t.Errorf("Reshape(3, 2).At(2, 0) = %g, want 5", r.At(2, 0))
+	}
+}
+
+func TestMatMul(t *testing.T) {
+	a := TensorFrom([]float32{1, 2, 3, 4, 5, 6}, 2, 3)
+	b := TensorFrom([]float32{7, 8, 9, 10, 11, 12}, 3, 2)
+
+	got := MatMul(a, b)
+	want := []float32{58, 64, 139, 154}
+	if !reflect.DeepEqual(got.Data, want) {
+		t.Errorf("MatMul = %v, want %v", got.Data, want)
+	}
+
+	// Transposed operands are plain strided views
+	got = MatMul(b.Transpose(), a.Transpose())
+	if !reflect.DeepEqual(got.Data, []float32{58, 139, 64, 154}) {
+		t.Errorf("MatMul of transposed views = %v", got.Data)
+	}
+}
@@ -0,0 +1,157 @@
+#!/usr/bin/env python3
+"""Builds the tiny HuggingFace-layout GPT-2 fixture used by hf_gpt2_test.go.
+
+Writes config.json and model.safetensors with random weights, named and laid
+out like transformers' GPT2LMHeadModel (Conv1D weights stored [in, out],
+tied lm_head omitted, attention mask buffers included), and
+reference_logits.json with the logits of a plain re-implementation of the
+GPT-2 forward pass. Only the standard library is needed.
+"""
+
+import json
+import math
+import os
+import random
+import struct
+
+VOCAB, POSITIONS, EMBD, HEADS, LAYERS = 13, 8, 8, 2, 2
+INPUT = [3, 1, 4, 1, 5, 9, 2]
+EPS = 1e-5
+
+rng = random.Random(0)
+
+
+def rand_tensor(*shape, scale=0.3, offset=0.0):
+    if len(shape) == 1:
+        return [offset + rng.gauss(0, scale) for _ in range(shape[0])]
+    return [rand_tensor(*shape[1:], scale=scale, offset=offset) for _ in range(shape[0])]
+
+
+def f32(x):
+    return struct.unpack("<f", struct.pack("<f", x))[0]
+
+
+def round_f32(t):
+    """Rounds weights to float32 so the reference sees what Go loads."""
+    return [round_f32(v) for v in t] if isinstance(t, list) else f32(t)
+
+
+weights = {
+    "transformer.wte.weight": rand_tensor(VOCAB, EMBD),
+    "transformer.wpe.weight": rand_tensor(POSITIONS, EMBD, scale=0.1),
+    "transformer.ln_f.weight": rand_tensor(EMBD, scale=0.1, offset=1.0),
+    "transformer.ln_f.bias": rand_tensor(EMBD, scale=0.1),
+}
+for i in range(LAYERS):
+    p = f"transformer.h.{i}."
+    weights.update({
+        p + "ln_1.weight": rand_tensor(EMBD, scale=0.1, offset=1.0),
+        p + "ln_1.bias": rand_tensor(EMBD, scale=0.1),
+        p + "attn.c_attn.weight": rand_tensor(EMBD, 3 * EMBD),
+        p + "attn.c_attn.bias": rand_tensor(3 * EMBD, scale=0.1),
+        p + "attn.c_proj.weight": rand_tensor(EMBD, EMBD),
+        p + "attn.c_proj.bias": rand_tensor(EMBD, scale=0.1),
+        p + "ln_2.weight": rand_tensor(EMBD, scale=0.1, offset=1.0),
+        p + "ln_2.bias": rand_tensor(EMBD, scale=0.1),
+        p + "mlp.c_fc.weight": rand_tensor(EMBD, 4 * EMBD),
+        p + "mlp.c_fc.bias": rand_tensor(4 * EMBD, scale=0.1),
+        p + "mlp.c_proj.weight": rand_tensor(4 * EMBD, EMBD),
+        p + "mlp.c_proj.bias": rand_tensor(EMBD, scale=0.1),
+    })
+weights = {name: round_f32(t) for name, t in weights.items()}
+
+
+def layer_norm(x, w, b):
+    mean = sum(x) / len(x)
+    var = sum((v - mean) ** 2 for v in x) / len(x)
+    return [(v - mean) / math.sqrt(var + EPS) * w[i] + b[i] for i, v in enumerate(x)]
+
+
+def conv1d(x, w, b):
+    return [b[j] + sum(x[i] * w[i][j] for i in range(len(x))) for j in range(len(b))]
+
+
+def gelu_new(v):
+    return 0.5 * v * (1 + math.tanh(math.sqrt(2 / math.pi) * (v + 0.044715 * v ** 3)))
+
+
+def forward(tokens):
+    W = weights
+    h = [[W["transformer.wte.weight"][t][j] + W["transformer.wpe.weight"][p][j] for j in range(EMBD)]
+         for p, t in enumerate(tokens)]
+    hd = EMBD // HEADS
+    for i in range(LAYERS):
+        p = f"transformer.h.{i}."
+        qkv = [conv1d(layer_norm(x, W[p + "ln_1.weight"], W[p + "ln_1.bias"]),
+                      W[p + "attn.c_attn.weight"], W[p + "attn.c_attn.bias"]) for x in h]
+        attn = []
+        for t in range(len(tokens)):
+            out = []
+            for head in range(HEADS):
+                q = qkv[t][head * hd:(head + 1) * hd]
+                scores = []
+                for s in range(t + 1):
+                    k = qkv[s][EMBD + head * hd:EMBD + (head + 1) * hd]
+                    scores.append(sum(a * b for a, b in zip(q, k)) / math.sqrt(hd))
+                m = max(scores)
+                exps = [math.exp(s - m) for s in scores]
+                total = sum(exps)
+                for j in range(hd):
+                    out.append(sum(exps[s] / total * qkv[s][2 * EMBD + head * hd + j] for s in range(t + 1)))
+            attn.append(out)
+        h = [[a + b for a, b in zip(x, conv1d(o, W[p + "attn.c_proj.weight"], W[p + "attn.c_proj.bias"]))]
+             for x, o in zip(h, attn)]
+        ff = [conv1d([gelu_new(v) for v in conv1d(layer_norm(x, W[p + "ln_2.weight"], W[p + "ln_2.bias"]),
+                                                     W[p + "mlp.c_fc.weight"], W[p + "mlp.c_fc.bias"])],
+                     W[p + "mlp.c_proj.weight"], W[p + "mlp.c_proj.bias"]) for x in h]
+        h = [[a + b for a, b in zip(x, f)] for x, f in zip(h, ff)]
+    h = [layer_norm(x, W["transformer.ln_f.weight"], W["transformer.ln_f.bias"]) for x in h]
+    wte = W["transformer.wte.weight"]
+    return [[sum(x[j] * wte[v][j] for j in range(EMBD)) for v in range(VOCAB)] for x in h]
+
+
+def flatten(t):
+    return [v for row in t for v in flatten(row)] if isinstance(t, list) else [t]
+
+
+def shape(t):
+    return [len(t)] + shape(t[0]) if isinstance(t, list) else []
+
+
+def write_safetensors(path):
+    tensors = dict(weights)
+    # Causal mask buffers as saved by older transformers versions; unused
+    for i in range(LAYERS):
+        tensors[f"transformer.h.{i}.attn.bias"] = [[[[1.0 if c <= r else 0.0 for c in range(POSITIONS)]
+                                                     for r in range(POSITIONS)]]]
+    header, data = {"__metadata__": {"format": "pt"}}, b""
+    for name, t in tensors.items():
+        values = flatten(t)
+        dtype = "U8" if name.endswith(".attn.bias") else "F32"
+        blob = bytes(int(v) for v in values) if dtype == "U8" else struct.pack(f"<{len(values)}f", *values)
+        header[name] = {"dtype": dtype, "shape": shape(t), "data_offsets": [len(data), len(data) + len(blob)]}
+        data += blob
+    encoded = json.dumps(header, separators=(",", ":")).encode()
+    encoded += b" " * (-len(encoded) % 8)
+    with open(path, "wb") as f:
+        f.write(struct.pack("<Q", len(encoded)) + encoded + data)
+
+
+here = os.path.dirname(os.path.abspath(__file__))
+with open(os.path.join(here, "config.json"), "w") as f:
+    json.dump({
+        "architectures": ["GPT2LMHeadModel"],
+        "activation_function": "gelu_new",
+        "layer_norm_epsilon": EPS,
+        "model_type": "gpt2",
+        "n_embd": EMBD,
+        "n_head": HEADS,
+        "n_layer": LAYERS,
+        "n_positions": POSITIONS,
+        "vocab_size": VOCAB,
+    }, f, indent=2)
+    f.write("\n")
+write_safetensors(os.path.join(here, "model.safetensors"))
+with open(os.path.join(here, "reference_logits.json"), "w") as f:
+    json.dump({"input": INPUT, "logits": forward(INPUT)}, f)
+    f.write("\n")
@@ -1,30 +1,84 @@
 package model
 
+import (
+	"fmt"
+	"math/rand"
+)
+
 type TransformerLayer struct {
 	Attention *MultiHeadAttention
 	FFN       *FeedForward
-	Norm1     *LayerNorm
-	Norm2     *LayerNorm
+	Norm1     Normalizer
+	Norm2     Normalizer
+
+	// PreNorm normalizes the input of each sublayer instead of the output of
+	// each residual addition
+	PreNorm bool
 }
 
-func NewTransformerLayer(embedDim, numHeads int) *TransformerLayer {
+func NewTransformerLayer(embedDim, numHeads, numKVHeads, ffnHiddenDim int, activation, normType string, rng *rand.Rand) *TransformerLayer {
 	return &TransformerLayer{
-		Attention: NewMultiHeadAttention(embedDim, numHeads),
-		FFN:       NewFeedForward(embedDim),
-		Norm1:     NewLayerNorm(embedDim),
-		Norm2:     NewLayerNorm(embedDim),
+		Attention: NewMultiHeadAttention(embedDim, numHeads, numKVHeads, rng),
+		FFN:       NewFeedForward(embedDim, ffnHiddenDim, activation, rng),
+		Norm1:     NewNormalizer(normType, embedDim),
+		Norm2:     NewNormalizer(normType, embedDim),
 	}
 }
 
-func (l *TransformerLayer) Forward(x [][]float32) [][]float32 {
+// Forward runs the layer over x; mask marks non-padding positions and may be
+// nil, see MultiHeadAttention.Forward.
+func (l *TransformerLayer) Forward(x *Tensor, mask []bool) *Tensor {
+	if l.PreNorm {
+		h := addTensors(x, l.Attention.Forward(l.Norm1.Apply(x), mask))
+		return addTensors(h, l.FFN.Forward(l.Norm2.Apply(h)))
+	}
+
 	// Self-attention with residual connection
-	attnOut := l.Attention.Forward(x)
-	residual := addVectors(x, attnOut)
+	attnOut := l.Attention.Forward(x, mask)
+	residual := addTensors(x, attnOut)
 	norm1Out := l.Norm1.Apply(residual)
 
 	// Feed-forward with residual connection
 	ffnOut := l.FFN.Forward(norm1Out)
-	residual = addVectors(norm1Out, ffnOut)
+	residual = addTensors(norm1Out, ffnOut)
 	return l.Norm2.Apply(residual)
 }
 
+// ForwardCached runs the layer over x, the positions following those already
+// in cache, using cached keys and values for the attention
+func (l *TransformerLayer) ForwardCached(x *Tensor, cache *LayerKVCache) *Tensor {
+	if l.PreNorm {
+		h := addTensors(x, l.Attention.ForwardCached(l.Norm1.Apply(x), cache))
+		return addTensors(h, l.FFN.Forward(l.Norm2.Apply(h)))
+	}
+
+	attnOut := l.Attention.ForwardCached(x, cache)
+	norm1Out := l.Norm1.Apply(addTensors(x, attnOut))
+
+	ffnOut := l.FFN.Forward(norm1Out)
+	return l.Norm2.Apply(addTensors(norm1Out, ffnOut))
+}
+
+// Backward runs the layer in reverse, splitting the gradient at each
+// residual connection, and returns the gradient of the layer input.
+func (l *TransformerLayer) Backward(dOut *Tensor) *Tensor {
+	if l.PreNorm {
+		dh := addTensors(dOut, l.Norm2.Backward(l.FFN.Backward(dOut)))
+		return addTensors(dh, l.Norm1.Backward(l.Attention.Backward(dh)))
+	}
+
+	dResidual := l.Norm2.Backward(dOut)
+	dNorm1 := addTensors(dResidual, l.FFN.Backward(dResidual))
+
+	dResidual = l.Norm1.Backward(dNorm1)
+	return addTensors(dResidual, l.Attention.Backward(dResidual))
+}
+
+func (l *TransformerLayer) parameters(index int) []*Parameter {
+	prefix := fmt.Sprintf("layers.%d.", index)
+
+	params := l.Attention.parameters(prefix)
+	params = append(params, l.Norm1.parameters(prefix+"norm1")...)
+	params = append(params, l.FFN.parameters(prefix)...)
+	return append(params, l.Norm2.parameters(prefix+"norm2")...)
+}
@@ -0,0 +1,44 @@
+package optim
+
+import "math"
+
+// AdamW is Adam with decoupled weight decay (Loshchilov & Hutter)
+type AdamW struct {
+	base
+	Beta1 float32
+	Beta2 float32
+	Eps   float32
+}
+
+func NewAdamW(groups []Group, lr, beta1, beta2 float32) *AdamW {
+	return &AdamW{
+		base:  newBase("adamw", groups, lr),
+		Beta1: beta1,
+		Beta2: beta2,
+		Eps:   1e-8,
+	}
+}
+
+func (a *AdamW) Step() {
+	a.step++
+	bias1 := 1 - float32(math.Pow(float64(a.Beta1), float64(a.step)))
+	bias2 := 1 - float32(math.Pow(float64(a.Beta2), float64(a.step)))
+
+	for _, g := range a.groups {
+		for _, p := range g.Params {
+			m := a.buffer("m", p)
+			v := a.buffer("v", p)
+			value := p.Value.Values()
+
+			for i, grad := range p.Grad.Values() {
+				m[i] = a.Beta1*m[i] + (1-a.Beta1)*grad
+				v[i] = a.Beta2*v[i] + (1-a.Beta2)*grad*grad
+
+				mHat := m[i] / bias1
+				vHat := v[i] / bias2
+				update := mHat/(float32(math.Sqrt(float64(vHat)))+a.Eps) + g.WeightDecay*value[i]
+				value[i] -= a.lr * update
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package optim
+
+// Lion is the sign-based optimizer found by symbolic search (Chen et al.).
+// It keeps a single momentum buffer and usually wants a learning rate 3-10x
+// smaller than AdamW with a correspondingly larger weight decay.
+type Lion struct {
+	base
+	Beta1 float32
+	Beta2 float32
+}
+
+func NewLion(groups []Group, lr, beta1, beta2 float32) *Lion {
+	return &Lion{
+		base:  newBase("lion", groups, lr),
+		Beta1: beta1,
+		Beta2: beta2,
+	}
+}
+
+func (l *Lion) Step() {
+	l.step++
+
+	for _, g := range l.groups {
+		for _, p := range g.Params {
+			m := l.buffer("m", p)
+			value := p.Value.Values()
+
+			for i, grad := range p.Grad.Values() {
+				update := sign(l.Beta1*m[i] + (1-l.Beta1)*grad)
+				value[i] -= l.lr * (update + g.WeightDecay*value[i])
+				m[i] = l.Beta2*m[i] + (1-l.Beta2)*grad
+			}
+		}
+	}
+}
+
+func sign(x float32) float32 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
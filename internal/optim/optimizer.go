@@ -0,0 +1,268 @@
+package optim
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gollm/internal/model"
+)
+
+// Optimizer updates model parameters from their accumulated gradients
+type Optimizer interface {
+	// Step applies one update to every parameter and advances the step count
+	Step()
+	LearningRate() float32
+	SetLearningRate(lr float32)
+	// StepCount returns the number of updates applied so far
+	StepCount() int
+	Save(path string) error
+	Load(path string) error
+}
+
+// Group is a set of parameters sharing the same weight decay
+type Group struct {
+	Params      []*model.Parameter
+	WeightDecay float32
+}
+
+// SplitDecay partitions params into a group that receives weightDecay and a
+// group holding biases and normalization gains and offsets, which are never
+// decayed.
+func SplitDecay(params []*model.Parameter, weightDecay float32) []Group {
+	decay := Group{WeightDecay: weightDecay}
+	noDecay := Group{}
+
+	for _, p := range params {
+		if isNoDecay(p.Name) {
+			noDecay.Params = append(noDecay.Params, p)
+		} else {
+			decay.Params = append(decay.Params, p)
+		}
+	}
+	return []Group{decay, noDecay}
+}
+
+func isNoDecay(name string) bool {
+	for _, suffix := range []string{"_bias", "_gamma", "_beta"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	stateMagic   = 0x476F4F70 // "GoOp" in hex
+	stateVersion = 1
+)
+
+// state is the on-disk representation shared by all optimizers. Buffers are
+// stored as rows of the parameter's last dimension.
+type state struct {
+	Kind         string                            `json:"kind"`
+	Step         int                               `json:"step"`
+	LearningRate float32                           `json:"learning_rate"`
+	Buffers      map[string]map[string][][]float32 `json:"buffers"`
+}
+
+// base holds the bookkeeping common to every optimizer: its parameter
+// groups, learning rate, step count and per-parameter state buffers
+type base struct {
+	kind    string
+	groups  []Group
+	lr      float32
+	step    int
+	buffers map[string]map[string][]float32 // buffer name -> parameter name -> values
+}
+
+func newBase(kind string, groups []Group, lr float32) base {
+	return base{
+		kind:    kind,
+		groups:  groups,
+		lr:      lr,
+		buffers: make(map[string]map[string][]float32),
+	}
+}
+
+func (b *base) LearningRate() float32 {
+	return b.lr
+}
+
+func (b *base) SetLearningRate(lr float32) {
+	b.lr = lr
+}
+
+func (b *base) StepCount() int {
+	return b.step
+}
+
+// buffer returns the named state buffer of p, allocating zeros on first use
+func (b *base) buffer(name string, p *model.Parameter) []float32 {
+	byParam, ok := b.buffers[name]
+	if !ok {
+		byParam = make(map[string][]float32)
+		b.buffers[name] = byParam
+	}
+
+	buf, ok := byParam[p.Name]
+	if !ok {
+		buf = make([]float32, p.Size())
+		byParam[p.Name] = buf
+	}
+	return buf
+}
+
+// Save writes the optimizer state to a file. The state is written to a
+// temporary file that then replaces path, so a crash never leaves a
+// truncated state behind.
+func (b *base) Save(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := binary.Write(f, binary.LittleEndian, uint32(stateMagic)); err != nil {
+		return fmt.Errorf("failed to write magic number: %v", err)
+	}
+	if err := binary.Write(f, binary.LittleEndian, uint32(stateVersion)); err != nil {
+		return fmt.Errorf("failed to write version: %v", err)
+	}
+
+	params := b.params()
+	st := state{
+		Kind:         b.kind,
+		Step:         b.step,
+		LearningRate: b.lr,
+		Buffers:      make(map[string]map[string][][]float32, len(b.buffers)),
+	}
+	for name, byParam := range b.buffers {
+		rows := make(map[string][][]float32, len(byParam))
+		for pname, buf := range byParam {
+			cols := len(buf)
+			if p, ok := params[pname]; ok && len(p.Value.Shape) > 0 {
+				cols = p.Value.Shape[len(p.Value.Shape)-1]
+			}
+			rows[pname] = splitRows(buf, cols)
+		}
+		st.Buffers[name] = rows
+	}
+	if err := json.NewEncoder(f).Encode(&st); err != nil {
+		return fmt.Errorf("failed to encode optimizer state: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set file mode: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename file: %v", err)
+	}
+	return nil
+}
+
+// Load restores optimizer state written by Save
+func (b *base) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	var magic, version uint32
+	if err := binary.Read(f, binary.LittleEndian, &magic); err != nil {
+		return fmt.Errorf("failed to read magic number: %v", err)
+	}
+	if magic != stateMagic {
+		return fmt.Errorf("invalid optimizer state format")
+	}
+	if err := binary.Read(f, binary.LittleEndian, &version); err != nil {
+		return fmt.Errorf("failed to read version: %v", err)
+	}
+	if version != stateVersion {
+		return fmt.Errorf("unsupported optimizer state version: %d", version)
+	}
+
+	var st state
+	if err := json.NewDecoder(f).Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode optimizer state: %v", err)
+	}
+	if st.Kind != b.kind {
+		return fmt.Errorf("optimizer mismatch: state is for %s, not %s", st.Kind, b.kind)
+	}
+
+	params := b.params()
+	buffers := make(map[string]map[string][]float32, len(st.Buffers))
+	for name, byParam := range st.Buffers {
+		flat := make(map[string][]float32, len(byParam))
+		for pname, rows := range byParam {
+			if p, ok := params[pname]; ok && !sameShape(rows, p) {
+				return fmt.Errorf("optimizer state %s for %s does not match parameter shape", name, pname)
+			}
+			flat[pname] = joinRows(rows)
+		}
+		buffers[name] = flat
+	}
+
+	b.step = st.Step
+	b.lr = st.LearningRate
+	b.buffers = buffers
+	return nil
+}
+
+// params indexes the optimizer's parameters by name
+func (b *base) params() map[string]*model.Parameter {
+	params := make(map[string]*model.Parameter)
+	for _, g := range b.groups {
+		for _, p := range g.Params {
+			params[p.Name] = p
+		}
+	}
+	return params
+}
+
+// splitRows cuts a flat buffer into rows of cols values
+func splitRows(buf []float32, cols int) [][]float32 {
+	if cols <= 0 {
+		return [][]float32{buf}
+	}
+	rows := make([][]float32, 0, len(buf)/cols)
+	for i := 0; i < len(buf); i += cols {
+		rows = append(rows, buf[i:min(i+cols, len(buf))])
+	}
+	return rows
+}
+
+// joinRows concatenates rows into a flat buffer
+func joinRows(rows [][]float32) []float32 {
+	var buf []float32
+	for _, row := range rows {
+		buf = append(buf, row...)
+	}
+	return buf
+}
+
+// sameShape reports whether rows has the row layout Save writes for p
+func sameShape(rows [][]float32, p *model.Parameter) bool {
+	shape := p.Value.Shape
+	if len(shape) == 0 {
+		return len(rows) == 1 && len(rows[0]) == p.Size()
+	}
+	cols := shape[len(shape)-1]
+	if cols == 0 || len(rows) != p.Size()/cols {
+		return false
+	}
+	for _, row := range rows {
+		if len(row) != cols {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package optim
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gollm/internal/model"
+)
+
+// quadratic returns a parameter whose loss is 0.5*||x - target||^2
+func quadratic(name string) *model.Parameter {
+	return &model.Parameter{
+		Name:  name,
+		Value: model.TensorFrom([]float32{3, -2, 1, 4}, 2, 2),
+		Grad:  model.NewTensor(2, 2),
+	}
+}
+
+func computeGrad(p *model.Parameter) float32 {
+	var loss float32
+	for i, v := range p.Value.Data {
+		p.Grad.Data[i] = v
+		loss += 0.5 * v * v
+	}
+	return loss
+}
+
+func TestOptimizers_MinimizeQuadratic(t *testing.T) {
+	tests := []struct {
+		name string
+		make func([]Group) Optimizer
+	}{
+		{"adamw", func(g []Group) Optimizer { return NewAdamW(g, 0.1, 0.9, 0.999) }},
+		{"sgd", func(g []Group) Optimizer { return NewSGD(g, 0.1, 0, false) }},
+		{"sgd momentum", func(g []Group) Optimizer { return NewSGD(g, 0.05, 0.9, false) }},
+		{"sgd nesterov", func(g []Group) Optimizer { return NewSGD(g, 0.05, 0.9, true) }},
+		{"lion", func(g []Group) Optimizer { return NewLion(g, 0.05, 0.9, 0.99) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := quadratic("w_weight")
+			opt := tt.make([]Group{{Params: []*model.Parameter{p}}})
+
+			initial := computeGrad(p)
+			for i := 0; i < 200; i++ {
+				computeGrad(p)
+				opt.Step()
+			}
+
+			if final := computeGrad(p); final > initial*0.05 {
+				t.Errorf("loss %.4f did not drop well below initial %.4f", final, initial)
+			}
+			if opt.StepCount() != 200 {
+				t.Errorf("StepCount() = %d, want 200", opt.StepCount())
+			}
+		})
+	}
+}
+
+func TestSplitDecay(t *testing.T) {
+	params := []*model.Parameter{
+		{Name: "token_embeddings"},
+		{Name: "layers.0.qkv_proj_weight"},
+		{Name: "layers.0.qkv_proj_bias"},
+		{Name: "layers.0.norm1_gamma"},
+		{Name: "final_norm_beta"},
+	}
+
+	groups := SplitDecay(params, 0.1)
+	if len(groups[0].Params) != 2 || groups[0].WeightDecay != 0.1 {
+		t.Errorf("decay group = %d params with decay %g", len(groups[0].Params), groups[0].WeightDecay)
+	}
+	if len(groups[1].Params) != 3 || groups[1].WeightDecay != 0 {
+		t.Errorf("no-decay group = %d params with decay %g", len(groups[1].Params), groups[1].WeightDecay)
+	}
+}
+
+func TestAdamW_SaveLoad(t *testing.T) {
+	p := quadratic("w_weight")
+	opt := NewAdamW([]Group{{Params: []*model.Parameter{p}, WeightDecay: 0.01}}, 0.1, 0.9, 0.999)
+	for i := 0; i < 3; i++ {
+		computeGrad(p)
+		opt.Step()
+	}
+
+	path := filepath.Join(t.TempDir(), "state.optim")
+	if err := opt.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if files, _ := filepath.Glob(path + "*"); len(files) != 1 {
+		t.Errorf("Save() left %v behind, want only the state file", files)
+	}
+
+	restored := NewAdamW([]Group{{Params: []*model.Parameter{p}, WeightDecay: 0.01}}, 0.5, 0.9, 0.999)
+	if err := restored.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if restored.StepCount() != 3 || restored.LearningRate() != 0.1 {
+		t.Errorf("restored step %d lr %g, want 3 and 0.1", restored.StepCount(), restored.LearningRate())
+	}
+	if !reflect.DeepEqual(restored.buffers, opt.buffers) {
+		t.Errorf("restored moment buffers differ from saved ones")
+	}
+
+	if err := NewLion(nil, 0.1, 0.9, 0.99).Load(path); err == nil {
+		t.Errorf("loading AdamW state into Lion should fail")
+	}
+}
+
+func TestSave_WritesRowsPerParameter(t *testing.T) {
+	p := quadratic("w_weight")
+	opt := NewSGD([]Group{{Params: []*model.Parameter{p}}}, 0.1, 0.9, false)
+	computeGrad(p)
+	opt.Step()
+
+	path := filepath.Join(t.TempDir(), "state.optim")
+	if err := opt.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st state
+	if err := json.Unmarshal(data[8:], &st); err != nil {
+		t.Fatalf("decoding state: %v", err)
+	}
+	rows := st.Buffers["velocity"]["w_weight"]
+	if len(rows) != 2 || len(rows[0]) != 2 || len(rows[1]) != 2 {
+		t.Errorf("saved velocity has rows %v, want 2 rows of 2 values", rows)
+	}
+}
@@ -0,0 +1,87 @@
+package optim
+
+import (
+	"fmt"
+	"math"
+)
+
+// Scheduler maps the global step, the number of updates applied so far, to
+// the learning rate of the next update
+type Scheduler interface {
+	LearningRate(step int) float32
+}
+
+// Schedule ramps the learning rate linearly from zero to BaseLR over
+// WarmupSteps and then follows the decay selected by Kind:
+//   - "constant": stay at BaseLR
+//   - "cosine": cosine curve from BaseLR down to MinLR at TotalSteps
+//   - "linear": straight line from BaseLR down to MinLR at TotalSteps
+//   - "step": multiply by Gamma every StepSize steps, never below MinLR
+type Schedule struct {
+	Kind        string
+	BaseLR      float32
+	MinLR       float32
+	WarmupSteps int
+	TotalSteps  int
+	StepSize    int
+	Gamma       float32
+}
+
+// NewSchedule validates the settings of a schedule and returns it
+func NewSchedule(kind string, baseLR, minLR float32, warmupSteps, totalSteps int) (*Schedule, error) {
+	switch kind {
+	case "":
+		kind = "constant"
+	case "constant", "cosine", "linear", "step":
+	default:
+		return nil, fmt.Errorf("unknown learning rate schedule %q", kind)
+	}
+	if warmupSteps < 0 {
+		return nil, fmt.Errorf("warmup steps must not be negative, got %d", warmupSteps)
+	}
+
+	return &Schedule{
+		Kind:        kind,
+		BaseLR:      baseLR,
+		MinLR:       minLR,
+		WarmupSteps: warmupSteps,
+		TotalSteps:  totalSteps,
+		StepSize:    1000,
+		Gamma:       0.1,
+	}, nil
+}
+
+func (s *Schedule) LearningRate(step int) float32 {
+	if step < s.WarmupSteps {
+		return s.BaseLR * float32(step+1) / float32(s.WarmupSteps)
+	}
+
+	decaySteps := step - s.WarmupSteps
+	switch s.Kind {
+	case "cosine":
+		progress := s.progress(decaySteps)
+		return s.MinLR + (s.BaseLR-s.MinLR)*0.5*float32(1+math.Cos(math.Pi*progress))
+	case "linear":
+		progress := s.progress(decaySteps)
+		return s.BaseLR + (s.MinLR-s.BaseLR)*float32(progress)
+	case "step":
+		if s.StepSize <= 0 {
+			return s.BaseLR
+		}
+		lr := s.BaseLR * float32(math.Pow(float64(s.Gamma), float64(decaySteps/s.StepSize)))
+		if lr < s.MinLR {
+			return s.MinLR
+		}
+		return lr
+	}
+	return s.BaseLR
+}
+
+// progress returns how far into the decay phase decaySteps is, in [0, 1]
+func (s *Schedule) progress(decaySteps int) float64 {
+	total := s.TotalSteps - s.WarmupSteps
+	if total <= 0 {
+		return 1
+	}
+	return math.Min(float64(decaySteps)/float64(total), 1)
+}
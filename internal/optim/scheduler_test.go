@@ -0,0 +1,48 @@
+package optim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSchedule_LearningRate(t *testing.T) {
+	tests := []struct {
+		kind string
+		step int
+		want float32
+	}{
+		{"constant", 0, 0.25},
+		{"constant", 3, 1},
+		{"constant", 500, 1},
+		{"cosine", 1, 0.5},
+		{"cosine", 4, 1},
+		{"cosine", 52, 0.55},
+		{"cosine", 100, 0.1},
+		{"cosine", 1000, 0.1},
+		{"linear", 52, 0.55},
+		{"linear", 100, 0.1},
+		{"step", 13, 1},
+		{"step", 14, 0.5},
+		{"step", 34, 0.125},
+		{"step", 1000, 0.1},
+	}
+
+	for _, tt := range tests {
+		s, err := NewSchedule(tt.kind, 1, 0.1, 4, 100)
+		if err != nil {
+			t.Fatalf("NewSchedule(%q) error = %v", tt.kind, err)
+		}
+		s.StepSize = 10
+		s.Gamma = 0.5
+
+		if got := s.LearningRate(tt.step); math.Abs(float64(got-tt.want)) > 1e-5 {
+			t.Errorf("%s: LearningRate(%d) = %g, want %g", tt.kind, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestNewSchedule_UnknownKind(t *testing.T) {
+	if _, err := NewSchedule("exponential", 1, 0, 0, 10); err == nil {
+		t.Errorf("expected an error for an unknown schedule")
+	}
+}
@@ -0,0 +1,46 @@
+package optim
+
+// SGD is stochastic gradient descent with optional (Nesterov) momentum.
+// Weight decay is applied as an L2 term added to the gradient.
+type SGD struct {
+	base
+	Momentum float32
+	Nesterov bool
+}
+
+func NewSGD(groups []Group, lr, momentum float32, nesterov bool) *SGD {
+	return &SGD{
+		base:     newBase("sgd", groups, lr),
+		Momentum: momentum,
+		Nesterov: nesterov,
+	}
+}
+
+func (s *SGD) Step() {
+	s.step++
+
+	for _, g := range s.groups {
+		for _, p := range g.Params {
+			var velocity []float32
+			if s.Momentum != 0 {
+				velocity = s.buffer("velocity", p)
+			}
+			value := p.Value.Values()
+
+			for i, grad := range p.Grad.Values() {
+				grad += g.WeightDecay * value[i]
+
+				if velocity != nil {
+					velocity[i] = s.Momentum*velocity[i] + grad
+					if s.Nesterov {
+						grad += s.Momentum * velocity[i]
+					} else {
+						grad = velocity[i]
+					}
+				}
+
+				value[i] -= s.lr * grad
+			}
+		}
+	}
+}
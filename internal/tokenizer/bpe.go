@@ -1,244 +1,267 @@
 package tokenizer
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"sort"
+	"runtime"
 	"strings"
 )
 
+// endOfWord marks the last symbol of each word
+const endOfWord = "</w>"
+
+// BytePairEncoder is a word-level BPE tokenizer. Text is split on whitespace
+// and every word is encoded as its characters followed by endOfWord, merged
+// by merge rank. Whitespace is not preserved: Decode joins the words with
+// single spaces.
 type BytePairEncoder struct {
 	merges   []string
 	vocab    *Vocab
 	maxVocab int
+	workers  int
+	specials Specials
+
+	// ranks and cache are derived from merges by resetEncoding
+	ranks map[symbolPair]int
+	cache *wordCache
 }
 
 func NewBPE(maxVocab int) *BytePairEncoder {
-	return &BytePairEncoder{
+	b := &BytePairEncoder{
 		merges:   []string{},
 		vocab:    NewVocab(),
 		maxVocab: maxVocab,
+		workers:  runtime.NumCPU(),
+		specials: DefaultSpecials(),
+	}
+	b.resetEncoding()
+	return b
+}
+
+// SetWorkers sets how many goroutines Train may use. Values below 1 restore
+// the default of runtime.NumCPU().
+func (b *BytePairEncoder) SetWorkers(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
 	}
+	b.workers = n
 }
 
+// Train learns merges from corpus until the vocabulary reaches its maximum
+// size or no pair of symbols is left. Each merge joins the most frequent
+// adjacent pair, ties going to the pair that sorts first.
 func (b *BytePairEncoder) Train(corpus string) {
 	fmt.Println("Preprocessing corpus...")
-	words := b.preprocessCorpus(corpus)
-	fmt.Printf("Found %d words\n", len(words))
+	counts := NewWordCounts(b.workers)
+	counts.Add(corpus)
+	b.TrainCounts(counts)
+}
+
+// TrainCounts learns merges like Train from the words of a corpus counted
+// beforehand, for corpora read in chunks
+func (b *BytePairEncoder) TrainCounts(counts *WordCounts) {
+	words := counts.words
+	total := 0
+	for _, w := range words {
+		total += w.count
+	}
+	fmt.Printf("Found %d words, %d distinct\n", total, len(words))
 
 	fmt.Println("Initializing vocabulary...")
 	b.initializeVocab(words)
 	fmt.Printf("Initial vocabulary size: %d\n", b.vocab.Len())
 
-	iteration := 0
+	trainer := newBPETrainer(words, b.workers)
 	for b.vocab.Len() < b.maxVocab {
-		iteration++
-		fmt.Printf("Training iteration %d (vocab size: %d/%d)\n", iteration, b.vocab.Len(), b.maxVocab)
-
-		pairs := b.getPairs(words)
-		if len(pairs) == 0 {
+		pair, count, ok := trainer.best()
+		if !ok {
 			fmt.Println("No more pairs to merge")
 			break
 		}
+		trainer.merge(pair)
+		b.merges = append(b.merges, pair.first+" "+pair.second)
+		b.vocab.Add(pair.first + pair.second)
 
-		bestPair := b.selectBestPair(pairs)
-		fmt.Printf("Selected pair: %q (frequency: %d)\n", bestPair, pairs[bestPair])
+		if len(b.merges)%1000 == 0 {
+			fmt.Printf("Learned %d merges (vocab size: %d/%d), last %q (frequency: %d)\n",
+				len(b.merges), b.vocab.Len(), b.maxVocab, pair.first+" "+pair.second, count)
+		}
+	}
+	fmt.Printf("Learned %d merges, vocabulary size: %d\n", len(b.merges), b.vocab.Len())
+	b.resetEncoding()
+}
 
-		words = b.mergePair(words, bestPair)
-		b.merges = append(b.merges, bestPair)
-		b.vocab.Add(strings.ReplaceAll(bestPair, " ", ""))
+// resetEncoding ranks the merges by the order they were learned in and
+// empties the word cache
+func (b *BytePairEncoder) resetEncoding() {
+	b.ranks = make(map[symbolPair]int, len(b.merges))
+	for rank, merge := range b.merges {
+		first, second, ok := strings.Cut(merge, " ")
+		if !ok || first == "" || second == "" {
+			continue
+		}
+		if _, ok := b.ranks[symbolPair{first, second}]; !ok {
+			b.ranks[symbolPair{first, second}] = rank
+		}
 	}
+	b.cache = newWordCache(defaultCacheSize)
 }
 
 func (b *BytePairEncoder) Vocab() *Vocab {
 	return b.vocab
 }
 
-func (b *BytePairEncoder) SaveVocab(path string) error {
-	data := struct {
-		Merges []string       `json:"merges"`
-		Vocab  map[string]int `json:"vocab"`
-	}{
-		Merges: b.merges,
-		Vocab:  b.vocab.word2id,
-	}
+// SetSpecials replaces the special tokens that Train adds to the vocabulary
+func (b *BytePairEncoder) SetSpecials(s Specials) {
+	b.specials = s
+}
 
-	file, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, file, 0644)
+// Save writes the merges, vocabulary and special tokens to path
+func (b *BytePairEncoder) Save(path string) error {
+	specials := b.specials
+	return writeVocabFile(path, &vocabFile{
+		Type:     TypeBPE,
+		Merges:   b.merges,
+		Vocab:    b.vocab.word2id,
+		Specials: &specials,
+	})
 }
 
-func (b *BytePairEncoder) LoadVocab(path string) error {
-	file, err := os.ReadFile(path)
+// Load reads merges and vocabulary written by Save
+func (b *BytePairEncoder) Load(path string) error {
+	vf, err := readVocabFile(path)
 	if err != nil {
 		return err
 	}
-
-	var data struct {
-		Merges []string       `json:"merges"`
-		Vocab  map[string]int `json:"vocab"`
-	}
-
-	if err := json.Unmarshal(file, &data); err != nil {
-		return err
+	if vf.Type != "" && vf.Type != TypeBPE {
+		return fmt.Errorf("%s holds a %s tokenizer, not BPE", path, vf.Type)
 	}
+	b.setVocabFile(vf)
+	return nil
+}
 
-	b.merges = data.Merges
+func (b *BytePairEncoder) setVocabFile(vf *vocabFile) {
+	b.merges = vf.Merges
 	b.vocab = NewVocab()
-	for word, id := range data.Vocab {
+	for word, id := range vf.Vocab {
 		b.vocab.word2id[word] = id
 		b.vocab.id2word[id] = word
 	}
-
-	return nil
-}
-
-func (b *BytePairEncoder) preprocessCorpus(corpus string) []string {
-	words := strings.Fields(corpus)
-	processed := make([]string, 0, len(words))
-
-	b.vocab.Add("<s>")   // Start of text
-	b.vocab.Add("</s>")  // End of text
-	b.vocab.Add("<unk>") // Unknown token
-	b.vocab.Add("<pad>") // Padding token
-	b.vocab.Add(" ")     // Space token
-
-	for _, word := range words {
-		if len(word) == 0 {
-			continue
-		}
-
-		chars := []string{" "}
-		for _, r := range word {
-			chars = append(chars, string(r))
-		}
-		chars = append(chars, "</w>")
-
-		processed = append(processed, strings.Join(chars, " "))
+	b.specials = DefaultSpecials()
+	if vf.Specials != nil {
+		b.specials = *vf.Specials
 	}
-
-	return processed
+	b.specials = b.specials.restrict(func(token string) bool { return b.vocab.GetID(token) >= 0 })
+	b.resetEncoding()
 }
 
-func (b *BytePairEncoder) initializeVocab(words []string) {
-	for _, word := range words {
-		chars := strings.Split(word, " ")
-		for _, char := range chars {
-			b.vocab.Add(char)
-		}
-	}
+// VocabSize returns the size of the vocabulary
+func (b *BytePairEncoder) VocabSize() int {
+	return b.vocab.Len()
 }
 
-func (b *BytePairEncoder) getPairs(words []string) map[string]int {
-	pairs := make(map[string]int)
-	for _, word := range words {
-		chars := strings.Split(word, " ")
-		for i := 0; i < len(chars)-1; i++ {
-			pair := chars[i] + " " + chars[i+1]
-			pairs[pair]++
-		}
-	}
-	return pairs
+// Token returns token id as stored in the vocabulary
+func (b *BytePairEncoder) Token(id int) string {
+	return b.vocab.GetWord(id)
 }
 
-func (b *BytePairEncoder) selectBestPair(pairs map[string]int) string {
-	type pair struct {
-		p string
-		c int
-	}
-
-	pairList := make([]pair, 0, len(pairs))
-	for p, c := range pairs {
-		pairList = append(pairList, pair{p, c})
-	}
+// Specials returns the registry of special tokens
+func (b *BytePairEncoder) Specials() Specials {
+	return b.specials
+}
 
-	sort.Slice(pairList, func(i, j int) bool {
-		if pairList[i].c != pairList[j].c {
-			return pairList[i].c > pairList[j].c
+// SpecialTokens returns the IDs of the special tokens in the vocabulary
+func (b *BytePairEncoder) SpecialTokens() map[string]int {
+	special := make(map[string]int)
+	for _, token := range b.specials.Tokens() {
+		if id := b.vocab.GetID(token); id >= 0 {
+			special[token] = id
 		}
-		return pairList[i].p < pairList[j].p
-	})
-
-	if len(pairList) > 0 {
-		return pairList[0].p
 	}
-	return ""
+	return special
 }
 
-func (b *BytePairEncoder) mergePair(words []string, bestPair string) []string {
-	parts := strings.Split(bestPair, " ")
-	if len(parts) != 2 {
-		return words
+// initializeVocab adds the special tokens and then every character of the
+// words, in the order they first appear
+func (b *BytePairEncoder) initializeVocab(words []wordCount) {
+	for _, token := range b.specials.Tokens() {
+		b.vocab.Add(token)
 	}
-
-	merged := strings.ReplaceAll(bestPair, " ", "")
-	result := make([]string, len(words))
-
-	for i, word := range words {
-		chars := strings.Split(word, " ")
-		var newChars []string
-
-		for j := 0; j < len(chars); j++ {
-			if j < len(chars)-1 && chars[j] == parts[0] && chars[j+1] == parts[1] {
-				newChars = append(newChars, merged)
-				j++
-			} else {
-				newChars = append(newChars, chars[j])
-			}
+	for _, w := range words {
+		for _, symbol := range wordSymbols(w.word) {
+			b.vocab.addCount(symbol, w.count)
 		}
-
-		result[i] = strings.Join(newChars, " ")
 	}
-
-	return result
 }
 
+// Encode converts text to token IDs. Symbols missing from the vocabulary
+// become <unk>.
 func (b *BytePairEncoder) Encode(text string) []int {
-	if len(text) == 0 {
-		return nil
-	}
-
-	words := strings.Fields(text)
 	var tokens []int
+	unkID := -1
+	if b.specials.UNK != "" {
+		unkID = b.vocab.GetID(b.specials.UNK)
+	}
 
-	for i, word := range words {
-		if len(word) == 0 {
+	for _, word := range strings.Fields(text) {
+		if ids, ok := b.cache.get(word); ok {
+			tokens = append(tokens, ids...)
 			continue
 		}
 
-		if i > 0 {
-			if spaceID := b.vocab.GetID(" "); spaceID >= 0 {
-				tokens = append(tokens, spaceID)
+		var ids []int
+		for _, symbol := range mergeByRank(wordSymbols(word), b.ranks) {
+			if id := b.vocab.GetID(symbol); id >= 0 {
+				ids = append(ids, id)
+			} else if unkID >= 0 {
+				ids = append(ids, unkID)
 			}
 		}
+		b.cache.add(word, ids)
+		tokens = append(tokens, ids...)
+	}
 
-		chars := make([]string, 0, len(word))
-		for _, c := range word {
-			chars = append(chars, string(c))
-		}
-		chars = append(chars, "</w>")
-		current := strings.Join(chars, " ")
+	return tokens
+}
 
-		for _, merge := range b.merges {
-			parts := strings.Split(merge, " ")
-			if len(parts) != 2 {
+// Decode converts token IDs back to text, joining the words with single
+// spaces. Special tokens are written as words of their own unless
+// opts.SkipSpecial is set; unknown IDs are skipped.
+func (b *BytePairEncoder) Decode(ids []int, opts DecodeOptions) string {
+	special := b.SpecialTokens()
+	var sb strings.Builder
+	for _, id := range ids {
+		token, ok := b.vocab.id2word[id]
+		if !ok {
+			continue
+		}
+		if _, ok := special[token]; ok {
+			if opts.SkipSpecial {
 				continue
 			}
-			current = strings.ReplaceAll(current, merge, strings.ReplaceAll(merge, " ", ""))
+			sb.WriteString(token)
+			sb.WriteByte(' ')
+			continue
 		}
-
-		subwords := strings.Split(current, " ")
-		for _, subword := range subwords {
-			if id := b.vocab.GetID(subword); id >= 0 {
-				tokens = append(tokens, id)
-			} else if unkID := b.vocab.GetID("<unk>"); unkID >= 0 {
-				tokens = append(tokens, unkID)
-			}
+		// Older vocabularies hold a separate space token; the ends of the
+		// words already say where the spaces go
+		if token == " " {
+			continue
+		}
+		if word, ok := strings.CutSuffix(token, endOfWord); ok {
+			sb.WriteString(word)
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteString(token)
 		}
 	}
+	return strings.TrimSuffix(sb.String(), " ")
+}
 
-	return tokens
+// wordSymbols splits a word into its characters followed by endOfWord
+func wordSymbols(word string) []string {
+	symbols := make([]string, 0, len(word)+1)
+	for _, r := range word {
+		symbols = append(symbols, string(r))
+	}
+	return append(symbols, endOfWord)
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -33,13 +34,13 @@ func TestBPE_SaveLoad(t *testing.T) {
 			tmpFile := filepath.Join(t.TempDir(), "vocab.json")
 			t.Cleanup(func() { os.Remove(tmpFile) })
 
-			if err := bpe1.SaveVocab(tmpFile); err != nil {
-				t.Fatalf("SaveVocab() error = %v", err)
+			if err := bpe1.Save(tmpFile); err != nil {
+				t.Fatalf("Save() error = %v", err)
 			}
 
 			bpe2 := NewBPE(tt.maxVocab)
-			if err := bpe2.LoadVocab(tmpFile); err != nil {
-				t.Fatalf("LoadVocab() error = %v", err)
+			if err := bpe2.Load(tmpFile); err != nil {
+				t.Fatalf("Load() error = %v", err)
 			}
 
 			if !reflect.DeepEqual(bpe1.vocab.id2word, bpe2.vocab.id2word) {
@@ -76,7 +77,7 @@ func TestBPE_Encode(t *testing.T) {
 			text: "what are you doing",
 			want: []int{135, 157, 69, 1880},
 			setup: func(bpe *BytePairEncoder) {
-				if err := bpe.LoadVocab("../../data/vocab/vocab.json"); err != nil {
+				if err := bpe.Load("../../data/vocab/vocab.json"); err != nil {
 					t.Fatalf("Failed to load vocab: %v", err)
 				}
 			},
@@ -97,3 +98,106 @@ func TestBPE_Encode(t *testing.T) {
 		})
 	}
 }
+
+// encodeSequential is the former encoder: every merge, in the order it was
+// learned, applied to the whole word. It is the reference for rank-based
+// encoding and the baseline of the benchmarks.
+func encodeSequential(b *BytePairEncoder, text string) []int {
+	var tokens []int
+	for _, word := range strings.Fields(text) {
+		symbols := wordSymbols(word)
+		for _, merge := range b.merges {
+			first, second, ok := strings.Cut(merge, " ")
+			if !ok || first == "" || second == "" {
+				continue
+			}
+			symbols = mergeSymbols(symbols, symbolPair{first, second})
+		}
+		for _, symbol := range symbols {
+			if id := b.vocab.GetID(symbol); id >= 0 {
+				tokens = append(tokens, id)
+			} else if unk := b.vocab.GetID("<unk>"); unk >= 0 {
+				tokens = append(tokens, unk)
+			}
+		}
+	}
+	return tokens
+}
+
+// loadShakespeare returns the test vocabulary and the first n bytes of the
+// Tiny Shakespeare corpus
+func loadShakespeare(tb testing.TB, n int) (*BytePairEncoder, string) {
+	tb.Helper()
+	bpe := NewBPE(0)
+	if err := bpe.Load("../../data/vocab/vocab.json"); err != nil {
+		tb.Fatalf("Failed to load vocab: %v", err)
+	}
+	data, err := os.ReadFile("../../data/tiny_shakespeare.txt")
+	if err != nil {
+		tb.Fatalf("Failed to read corpus: %v", err)
+	}
+	if len(data) > n {
+		data = data[:n]
+	}
+	return bpe, string(data)
+}
+
+func TestBPE_EncodeMatchesSequentialMerges(t *testing.T) {
+	bpe, text := loadShakespeare(t, 4000)
+
+	want := encodeSequential(bpe, text)
+	if got := bpe.Encode(text); !slicesEqual(got, want) {
+		t.Fatalf("rank-based Encode differs from sequential merging")
+	}
+	// The second pass is served from the cache
+	if got := bpe.Encode(text); !slicesEqual(got, want) {
+		t.Fatalf("cached Encode differs from sequential merging")
+	}
+}
+
+func TestBPE_EncodeOverlappingMerges(t *testing.T) {
+	// Once "b a" has merged, the "a" of "ba" must not merge with the next "b",
+	// as replacing "a b" in the string "ba b </w>" would
+	bpe := NewBPE(0)
+	bpe.merges = []string{"b a", "a b"}
+	for _, token := range []string{"<unk>", "a", "b", endOfWord, "ba", "ab"} {
+		bpe.vocab.Add(token)
+	}
+	bpe.resetEncoding()
+
+	got := bpe.Encode("bab")
+	want := []int{bpe.vocab.GetID("ba"), bpe.vocab.GetID("b"), bpe.vocab.GetID(endOfWord)}
+	if !slicesEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", "bab", got, want)
+	}
+}
+
+const benchmarkBytes = 16 << 10
+
+func BenchmarkBPE_Encode(b *testing.B) {
+	bpe, text := loadShakespeare(b, benchmarkBytes)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpe.Encode(text)
+	}
+}
+
+func BenchmarkBPE_EncodeUncached(b *testing.B) {
+	bpe, text := loadShakespeare(b, benchmarkBytes)
+	bpe.cache = newWordCache(0)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpe.Encode(text)
+	}
+}
+
+func BenchmarkBPE_EncodeSequential(b *testing.B) {
+	bpe, text := loadShakespeare(b, benchmarkBytes)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encodeSequential(bpe, text)
+	}
+}
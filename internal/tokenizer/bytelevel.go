@@ -0,0 +1,270 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ByteLevelBPE is the byte-level BPE tokenizer of GPT-2. Text is split with
+// the GPT-2 pre-tokenization rules, every byte of a piece is mapped to a
+// printable character, and the characters are merged by merge rank. Any
+// byte sequence can be encoded, so there is no unknown token.
+type ByteLevelBPE struct {
+	encoder  map[string]int
+	decoder  map[int]string
+	merges   []symbolPair
+	ranks    map[symbolPair]int
+	specials Specials
+}
+
+// NewByteLevelBPE creates a tokenizer from a vocabulary of byte-level tokens
+// and its merges, ordered from highest to lowest priority. GPT-2's
+// <|endoftext|> both begins and ends texts if the vocabulary has it.
+func NewByteLevelBPE(encoder map[string]int, merges []symbolPair) *ByteLevelBPE {
+	b := &ByteLevelBPE{
+		encoder:  encoder,
+		decoder:  make(map[int]string, len(encoder)),
+		merges:   merges,
+		ranks:    make(map[symbolPair]int, len(merges)),
+		specials: Specials{BOS: endOfText, EOS: endOfText},
+	}
+	for token, id := range encoder {
+		b.decoder[id] = token
+	}
+	for rank, m := range merges {
+		if _, ok := b.ranks[m]; !ok {
+			b.ranks[m] = rank
+		}
+	}
+	b.SetSpecials(b.specials)
+	return b
+}
+
+// SetSpecials replaces the special tokens. Tokens missing from the
+// vocabulary are dropped.
+func (b *ByteLevelBPE) SetSpecials(s Specials) {
+	b.specials = s.restrict(func(token string) bool {
+		_, ok := b.encoder[token]
+		return ok
+	})
+}
+
+// LoadByteLevelBPE reads a GPT-2 vocabulary (encoder.json, called vocab.json
+// by HuggingFace) and merges file (vocab.bpe, or merges.txt)
+func LoadByteLevelBPE(encoderPath, mergesPath string) (*ByteLevelBPE, error) {
+	data, err := os.ReadFile(encoderPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encoder: %v", err)
+	}
+	var encoder map[string]int
+	if err := json.Unmarshal(data, &encoder); err != nil {
+		return nil, fmt.Errorf("failed to parse encoder: %v", err)
+	}
+
+	merges, err := readMerges(mergesPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewByteLevelBPE(encoder, merges), nil
+}
+
+// LoadByteLevelBPEDir loads the GPT-2 vocabulary and merges from dir, under
+// either the original names (encoder.json, vocab.bpe) or the HuggingFace ones
+// (vocab.json, merges.txt)
+func LoadByteLevelBPEDir(dir string) (*ByteLevelBPE, error) {
+	find := func(names ...string) string {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		return filepath.Join(dir, names[0])
+	}
+	return LoadByteLevelBPE(find("encoder.json", "vocab.json"), find("vocab.bpe", "merges.txt"))
+}
+
+// newByteLevelBPEFromFile creates a tokenizer from a vocabulary file written
+// by Save
+func newByteLevelBPEFromFile(vf *vocabFile) (*ByteLevelBPE, error) {
+	merges := make([]symbolPair, len(vf.Merges))
+	for i, m := range vf.Merges {
+		first, second, ok := strings.Cut(m, " ")
+		if !ok || first == "" || second == "" {
+			return nil, fmt.Errorf("merge %d: expected two symbols, got %q", i, m)
+		}
+		merges[i] = symbolPair{first, second}
+	}
+	b := NewByteLevelBPE(vf.Vocab, merges)
+	if vf.Specials != nil {
+		b.SetSpecials(*vf.Specials)
+	}
+	return b, nil
+}
+
+// readMerges parses a merges file: an optional "#version" line followed by
+// one space-separated pair per line
+func readMerges(path string) ([]symbolPair, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open merges: %v", err)
+	}
+	defer f.Close()
+
+	var merges []symbolPair
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" || (line == 1 && strings.HasPrefix(text, "#")) {
+			continue
+		}
+		first, second, ok := strings.Cut(text, " ")
+		if !ok || first == "" || second == "" {
+			return nil, fmt.Errorf("merges line %d: expected two symbols, got %q", line, text)
+		}
+		merges = append(merges, symbolPair{first, second})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read merges: %v", err)
+	}
+	return merges, nil
+}
+
+// endOfText separates documents in GPT-2's vocabulary
+const endOfText = "<|endoftext|>"
+
+// Encode converts text to token IDs. Byte symbols missing from a partial
+// vocabulary are dropped.
+func (b *ByteLevelBPE) Encode(text string) []int {
+	var ids []int
+	for _, piece := range pretokenize(text) {
+		for _, symbol := range mergeByRank(byteSymbols(piece), b.ranks) {
+			if id, ok := b.encoder[symbol]; ok {
+				ids = append(ids, id)
+				continue
+			}
+			for _, r := range symbol {
+				if id, ok := b.encoder[string(r)]; ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// Decode converts token IDs back to text. Special tokens are written as they
+// are spelled unless opts.SkipSpecial is set, and unknown IDs are skipped;
+// byte sequences that are not valid UTF-8 are kept as they are.
+func (b *ByteLevelBPE) Decode(ids []int, opts DecodeOptions) string {
+	special := b.SpecialTokens()
+	var sb strings.Builder
+	for _, id := range ids {
+		token := b.decoder[id]
+		if _, ok := special[token]; ok {
+			if opts.SkipSpecial {
+				continue
+			}
+			sb.WriteString(token)
+			continue
+		}
+		for _, r := range token {
+			if c, ok := unicodeToByte[r]; ok {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// VocabSize returns the size of the vocabulary
+func (b *ByteLevelBPE) VocabSize() int {
+	return len(b.encoder)
+}
+
+// Token returns token id as stored in the vocabulary, with the bytes mapped
+// to printable characters
+func (b *ByteLevelBPE) Token(id int) string {
+	return b.decoder[id]
+}
+
+// Specials returns the registry of special tokens
+func (b *ByteLevelBPE) Specials() Specials {
+	return b.specials
+}
+
+// SpecialTokens returns the IDs of the special tokens in the vocabulary
+func (b *ByteLevelBPE) SpecialTokens() map[string]int {
+	special := make(map[string]int)
+	for _, token := range b.specials.Tokens() {
+		special[token] = b.encoder[token]
+	}
+	return special
+}
+
+// Save writes the vocabulary and merges in the gollm vocabulary file format
+func (b *ByteLevelBPE) Save(path string) error {
+	merges := make([]string, len(b.merges))
+	for i, m := range b.merges {
+		merges[i] = m.first + " " + m.second
+	}
+	specials := b.specials
+	return writeVocabFile(path, &vocabFile{
+		Type:     TypeByteLevel,
+		Merges:   merges,
+		Vocab:    b.encoder,
+		Specials: &specials,
+	})
+}
+
+// Load reads a vocabulary file written by Save
+func (b *ByteLevelBPE) Load(path string) error {
+	vf, err := readVocabFile(path)
+	if err != nil {
+		return err
+	}
+	if vf.Type != TypeByteLevel {
+		return fmt.Errorf("%s does not hold a byte-level BPE tokenizer", path)
+	}
+	loaded, err := newByteLevelBPEFromFile(vf)
+	if err != nil {
+		return err
+	}
+	*b = *loaded
+	return nil
+}
+
+// byteSymbols maps every byte of piece to its printable character
+func byteSymbols(piece string) []string {
+	symbols := make([]string, len(piece))
+	for i := 0; i < len(piece); i++ {
+		symbols[i] = string(byteToUnicode[piece[i]])
+	}
+	return symbols
+}
+
+// byteToUnicode is GPT-2's reversible mapping from bytes to printable
+// characters: printable Latin-1 bytes map to themselves, the others to the
+// characters from U+0100 on, so whitespace and control bytes never appear in
+// the vocabulary (a space becomes 'Ġ').
+var byteToUnicode, unicodeToByte = buildByteTables()
+
+func buildByteTables() ([256]rune, map[rune]byte) {
+	var table [256]rune
+	reverse := make(map[rune]byte, 256)
+	n := 0
+	for c := 0; c < 256; c++ {
+		if ('!' <= c && c <= '~') || ('¡' <= c && c <= '¬') || ('®' <= c && c <= 'ÿ') {
+			table[c] = rune(c)
+		} else {
+			table[c] = rune(256 + n)
+			n++
+		}
+		reverse[table[c]] = byte(c)
+	}
+	return table, reverse
+}
@@ -0,0 +1,132 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPretokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello world", []string{"Hello", " world"}},
+		{"I'm here, they'll see", []string{"I", "'m", " here", ",", " they", "'ll", " see"}},
+		{"a  b", []string{"a", " ", " b"}},
+		{"line\n\nnext", []string{"line", "\n", "\n", "next"}},
+		{"trailing   ", []string{"trailing", "   "}},
+		{"year 2024!!", []string{"year", " 2024", "!!"}},
+		{"héllo wörld", []string{"héllo", " wörld"}},
+		{" $5", []string{" $", "5"}},
+	}
+
+	for _, tt := range tests {
+		if got := pretokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pretokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestByteToUnicode(t *testing.T) {
+	if got := byteToUnicode[' ']; got != 'Ġ' {
+		t.Errorf("space maps to %q, want 'Ġ'", got)
+	}
+	if got := byteToUnicode['\n']; got != 'Ċ' {
+		t.Errorf("newline maps to %q, want 'Ċ'", got)
+	}
+	if got := byteToUnicode['A']; got != 'A' {
+		t.Errorf("'A' maps to %q, want 'A'", got)
+	}
+	if len(unicodeToByte) != 256 {
+		t.Errorf("mapping is not a bijection: %d distinct characters", len(unicodeToByte))
+	}
+}
+
+// newTestByteLevelBPE writes a small GPT-2 style vocabulary to disk and loads
+// it: every byte plus the merges needed for "Hello world"
+func newTestByteLevelBPE(t *testing.T) *ByteLevelBPE {
+	t.Helper()
+	merges := []string{"#version: 0.2", "l l", "H e", "He ll", "Hell o", "Ġ w", "o r", "Ġw or", "l d", "Ġwor ld"}
+
+	encoder := make(map[string]int)
+	for c := 0; c < 256; c++ {
+		encoder[string(byteToUnicode[c])] = len(encoder)
+	}
+	for _, m := range merges[1:] {
+		encoder[strings.ReplaceAll(m, " ", "")] = len(encoder)
+	}
+
+	dir := t.TempDir()
+	data, err := json.Marshal(encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "encoder.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vocab.bpe"), []byte(strings.Join(merges, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := LoadByteLevelBPEDir(dir)
+	if err != nil {
+		t.Fatalf("LoadByteLevelBPEDir() error = %v", err)
+	}
+	return b
+}
+
+func TestByteLevelBPE_Encode(t *testing.T) {
+	b := newTestByteLevelBPE(t)
+
+	got := b.Encode("Hello world")
+	want := []int{b.encoder["Hello"], b.encoder["Ġworld"]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", "Hello world", got, want)
+	}
+
+	// "ll" has the best rank, so "Hello" must not merge into "He" + "llo"
+	got = b.Encode("Hellllo")
+	want = []int{b.encoder["Hell"], b.encoder["ll"], b.encoder["o"]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", "Hellllo", got, want)
+	}
+}
+
+func TestByteLevelBPE_RoundTrip(t *testing.T) {
+	b := newTestByteLevelBPE(t)
+
+	for _, text := range []string{
+		"Hello world",
+		"  leading and trailing spaces  ",
+		"tabs\tand\nnewlines\r\n",
+		"unicode: héllo, 世界, emoji 🙂",
+		"",
+	} {
+		if got := b.Decode(b.Encode(text), DecodeOptions{}); got != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, got)
+		}
+	}
+}
+
+func TestByteLevelBPE_EndOfText(t *testing.T) {
+	encoder := map[string]int{"a": 0, "b": 1, endOfText: 2}
+	b := NewByteLevelBPE(encoder, nil)
+
+	if s := b.Specials(); s.BOS != endOfText || s.EOS != endOfText {
+		t.Errorf("Specials() = %+v, want <|endoftext|> as BOS and EOS", s)
+	}
+	ids := EncodeSpecial(b, "ab<|endoftext|>a")
+	if want := []int{0, 1, 2, 0}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("EncodeSpecial() = %v, want %v", ids, want)
+	}
+	if got := b.Decode(ids, DecodeOptions{}); got != "ab<|endoftext|>a" {
+		t.Errorf("Decode() = %q", got)
+	}
+	if got := b.Decode(ids, DecodeOptions{SkipSpecial: true}); got != "aba" {
+		t.Errorf("Decode(SkipSpecial) = %q, want %q", got, "aba")
+	}
+}
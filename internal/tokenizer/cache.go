@@ -0,0 +1,64 @@
+package tokenizer
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultCacheSize is the number of encoded words a tokenizer remembers
+const defaultCacheSize = 10000
+
+// wordCache is a least-recently-used cache from words to their token IDs,
+// safe for concurrent use. A cache of size 0 stores nothing.
+type wordCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	word string
+	ids  []int
+}
+
+func newWordCache(size int) *wordCache {
+	return &wordCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the IDs cached for word. They must not be modified.
+func (c *wordCache) get(word string) ([]int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[word]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).ids, true
+}
+
+// add caches the IDs of word, evicting the least recently used word when
+// the cache is full
+func (c *wordCache) add(word string, ids []int) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[word]; ok {
+		e.Value.(*cacheEntry).ids = ids
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).word)
+	}
+	c.items[word] = c.order.PushFront(&cacheEntry{word, ids})
+}
@@ -0,0 +1,31 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWordCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newWordCache(2)
+	c.add("a", []int{1})
+	c.add("b", []int{2})
+	c.get("a")
+	c.add("c", []int{3})
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("least recently used word was not evicted")
+	}
+	for word, want := range map[string][]int{"a": {1}, "c": {3}} {
+		if got, ok := c.get(word); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("get(%q) = %v, %v, want %v", word, got, ok, want)
+		}
+	}
+}
+
+func TestWordCache_ZeroSize(t *testing.T) {
+	c := newWordCache(0)
+	c.add("a", []int{1})
+	if _, ok := c.get("a"); ok {
+		t.Errorf("cache of size 0 stored a word")
+	}
+}
@@ -0,0 +1,42 @@
+package tokenizer
+
+// symbolPair is two adjacent symbols that a BPE merge joins into one
+type symbolPair struct {
+	first, second string
+}
+
+// mergeByRank applies BPE merges to the symbols of one word: while any
+// adjacent pair has a rank, every occurrence of the lowest-ranked pair is
+// merged, left to right.
+func mergeByRank(symbols []string, ranks map[symbolPair]int) []string {
+	for len(symbols) > 1 {
+		best, bestRank := symbolPair{}, -1
+		for i := 0; i < len(symbols)-1; i++ {
+			pair := symbolPair{symbols[i], symbols[i+1]}
+			if rank, ok := ranks[pair]; ok && (bestRank < 0 || rank < bestRank) {
+				best, bestRank = pair, rank
+			}
+		}
+		if bestRank < 0 {
+			break
+		}
+
+		symbols = mergeSymbols(symbols, best)
+	}
+	return symbols
+}
+
+// mergeSymbols replaces every occurrence of pair in symbols, left to right,
+// with the merged symbol
+func mergeSymbols(symbols []string, pair symbolPair) []string {
+	merged := symbols[:0:0]
+	for i := 0; i < len(symbols); i++ {
+		if i < len(symbols)-1 && symbols[i] == pair.first && symbols[i+1] == pair.second {
+			merged = append(merged, pair.first+pair.second)
+			i++
+		} else {
+			merged = append(merged, symbols[i])
+		}
+	}
+	return merged
+}
@@ -0,0 +1,80 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// contractions are the suffixes GPT-2 splits off as pieces of their own
+var contractions = []string{"'s", "'t", "'re", "'ve", "'m", "'ll", "'d"}
+
+// pretokenize splits text into the pieces GPT-2 encodes independently. It
+// implements the GPT-2 pattern
+//
+//	's|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+
+//
+// by hand, since RE2 has no lookahead.
+func pretokenize(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		n := pieceLen(text)
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+// pieceLen returns the byte length of the piece at the start of text
+func pieceLen(text string) int {
+	for _, c := range contractions {
+		if strings.HasPrefix(text, c) {
+			return len(c)
+		}
+	}
+
+	// An optional space followed by a run of letters, numbers or other
+	// symbols
+	start := 0
+	if text[0] == ' ' {
+		start = 1
+	}
+	if start < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[start:])
+		for _, class := range []func(rune) bool{isLetter, isNumber, isOther} {
+			if class(r) {
+				return start + runLen(text[start:], class)
+			}
+		}
+	}
+
+	// Whitespace, leaving the last character of a run for the next piece if
+	// the run is followed by anything else, so " word" stays together
+	n := runLen(text, unicode.IsSpace)
+	if n < len(text) {
+		_, last := utf8.DecodeLastRuneInString(text[:n])
+		if n-last > 0 {
+			return n - last
+		}
+	}
+	return n
+}
+
+// runLen returns the byte length of the longest prefix of text whose runes
+// all satisfy class
+func runLen(text string, class func(rune) bool) int {
+	for i, r := range text {
+		if !class(r) {
+			return i
+		}
+	}
+	return len(text)
+}
+
+func isLetter(r rune) bool { return unicode.IsLetter(r) }
+
+func isNumber(r rune) bool { return unicode.IsNumber(r) }
+
+func isOther(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
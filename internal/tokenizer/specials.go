@@ -0,0 +1,99 @@
+package tokenizer
+
+import (
+	"sort"
+	"strings"
+)
+
+// Specials is the registry of special tokens of a vocabulary: the tokens
+// that mark the beginning and end of a text, unknown symbols and padding,
+// plus any user-defined ones. It is saved in the vocabulary file. Roles left
+// empty are not used.
+type Specials struct {
+	BOS        string   `json:"bos,omitempty"`
+	EOS        string   `json:"eos,omitempty"`
+	UNK        string   `json:"unk,omitempty"`
+	PAD        string   `json:"pad,omitempty"`
+	Additional []string `json:"additional,omitempty"`
+}
+
+// DefaultSpecials returns the special tokens of a newly trained BPE
+// vocabulary
+func DefaultSpecials() Specials {
+	return Specials{BOS: "<s>", EOS: "</s>", UNK: "<unk>", PAD: "<pad>"}
+}
+
+// Tokens returns every special token once: BOS, EOS, UNK and PAD, then the
+// additional tokens
+func (s Specials) Tokens() []string {
+	var tokens []string
+	seen := make(map[string]bool)
+	for _, token := range append([]string{s.BOS, s.EOS, s.UNK, s.PAD}, s.Additional...) {
+		if token != "" && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// restrict clears the roles and drops the additional tokens that are not
+// in the vocabulary
+func (s Specials) restrict(inVocab func(string) bool) Specials {
+	for _, role := range []*string{&s.BOS, &s.EOS, &s.UNK, &s.PAD} {
+		if *role != "" && !inVocab(*role) {
+			*role = ""
+		}
+	}
+	var additional []string
+	for _, token := range s.Additional {
+		if inVocab(token) {
+			additional = append(additional, token)
+		}
+	}
+	s.Additional = additional
+	return s
+}
+
+// SpecialID returns the ID of a special token of tok, such as
+// tok.Specials().EOS, or false if the role is not set
+func SpecialID(tok Interface, token string) (int, bool) {
+	if token == "" {
+		return 0, false
+	}
+	id, ok := tok.SpecialTokens()[token]
+	return id, ok
+}
+
+// EncodeSpecial encodes text like tok.Encode, except that special tokens
+// written out in the text are encoded as their own IDs instead of as
+// ordinary text. Only use it on trusted text.
+func EncodeSpecial(tok Interface, text string) []int {
+	special := tok.SpecialTokens()
+	tokens := make([]string, 0, len(special))
+	for token := range special {
+		tokens = append(tokens, token)
+	}
+	// Prefer the longest token when one special token starts another
+	sort.Slice(tokens, func(i, j int) bool {
+		if len(tokens[i]) != len(tokens[j]) {
+			return len(tokens[i]) > len(tokens[j])
+		}
+		return tokens[i] < tokens[j]
+	})
+
+	var ids []int
+	start := 0
+	for i := 0; i < len(text); i++ {
+		for _, token := range tokens {
+			if strings.HasPrefix(text[i:], token) {
+				ids = append(ids, tok.Encode(text[start:i])...)
+				ids = append(ids, special[token])
+				i += len(token) - 1
+				start = i + 1
+				break
+			}
+		}
+	}
+	return append(ids, tok.Encode(text[start:])...)
+}
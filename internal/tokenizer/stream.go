@@ -0,0 +1,71 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// StreamDecoder turns token IDs into text one token at a time. It only
+// emits whole UTF-8 characters, holding back the bytes of a character that
+// the next token may complete, so the fragments can be printed as they
+// arrive.
+type StreamDecoder struct {
+	tok    Interface
+	opts   DecodeOptions
+	ids    []int
+	prefix int // first token decoded again for context
+	read   int // tokens whose text has been emitted
+}
+
+// NewStreamDecoder returns a decoder for tok that decodes with opts
+func NewStreamDecoder(tok Interface, opts DecodeOptions) *StreamDecoder {
+	return &StreamDecoder{tok: tok, opts: opts}
+}
+
+// Add decodes the next token and returns the text it completes, which may
+// be empty
+func (d *StreamDecoder) Add(id int) string {
+	d.ids = append(d.ids, id)
+
+	// The text of the new tokens is what they add to the text of the
+	// tokens before them, so tokenizers that separate words see the last
+	// word already emitted
+	prefixText := d.tok.Decode(d.ids[d.prefix:d.read], d.opts)
+	text := d.tok.Decode(d.ids[d.prefix:], d.opts)
+	if len(text) <= len(prefixText) || !strings.HasPrefix(text, prefixText) {
+		return ""
+	}
+	text = text[len(prefixText):]
+
+	if completeUTF8(text) < len(text) {
+		return ""
+	}
+	d.prefix, d.read = d.read, len(d.ids)
+	return text
+}
+
+// Flush returns the text held back, even if it does not end in a whole
+// character
+func (d *StreamDecoder) Flush() string {
+	prefixText := d.tok.Decode(d.ids[d.prefix:d.read], d.opts)
+	text := d.tok.Decode(d.ids[d.prefix:], d.opts)
+	d.prefix, d.read = len(d.ids), len(d.ids)
+	if !strings.HasPrefix(text, prefixText) {
+		return ""
+	}
+	return text[len(prefixText):]
+}
+
+// completeUTF8 returns the length of s without a trailing partial UTF-8
+// character that more bytes could complete
+func completeUTF8(s string) int {
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(s)
+}
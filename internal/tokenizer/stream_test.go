@@ -0,0 +1,65 @@
+package tokenizer
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// streamDecode decodes ids one at a time and returns the fragments
+func streamDecode(d *StreamDecoder, ids []int) []string {
+	var fragments []string
+	for _, id := range ids {
+		if text := d.Add(id); text != "" {
+			fragments = append(fragments, text)
+		}
+	}
+	if text := d.Flush(); text != "" {
+		fragments = append(fragments, text)
+	}
+	return fragments
+}
+
+func TestStreamDecoder_SplitsOnlyWholeCharacters(t *testing.T) {
+	b := newTestByteLevelBPE(t)
+	text := "Hello world, 世界 🙂!"
+	ids := b.Encode(text)
+
+	fragments := streamDecode(NewStreamDecoder(b, DecodeOptions{}), ids)
+	if got := strings.Join(fragments, ""); got != text {
+		t.Errorf("fragments join to %q, want %q", got, text)
+	}
+	for _, f := range fragments {
+		if !utf8.ValidString(f) {
+			t.Errorf("fragment %q is not valid UTF-8", f)
+		}
+	}
+	// Every character of 世界 and 🙂 spans several byte tokens
+	if len(fragments) >= len(ids) {
+		t.Errorf("got %d fragments for %d tokens, want multi-byte characters held back", len(fragments), len(ids))
+	}
+}
+
+func TestStreamDecoder_BPEWords(t *testing.T) {
+	bpe := NewBPE(60)
+	bpe.Train(roundTripCorpus)
+	ids := bpe.Encode("hear me speak")
+	ids = append(ids, bpe.SpecialTokens()["</s>"])
+
+	fragments := streamDecode(NewStreamDecoder(bpe, DecodeOptions{SkipSpecial: true}), ids)
+	if got := strings.Join(fragments, ""); got != "hear me speak" {
+		t.Errorf("fragments join to %q, want %q", got, "hear me speak")
+	}
+}
+
+func TestStreamDecoder_FlushIncomplete(t *testing.T) {
+	b := newTestByteLevelBPE(t)
+	ids := b.Encode("é")
+	d := NewStreamDecoder(b, DecodeOptions{})
+	if got := d.Add(ids[0]); got != "" {
+		t.Errorf("Add() = %q, want the partial character held back", got)
+	}
+	if got := d.Flush(); got != "\xc3" {
+		t.Errorf("Flush() = %q, want the held back byte", got)
+	}
+}
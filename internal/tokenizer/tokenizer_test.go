@@ -0,0 +1,180 @@
+package tokenizer
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const roundTripCorpus = `First Citizen:
+Before we proceed any further, hear me speak.
+
+All:
+Speak, speak.`
+
+// saveAndLoad saves tok and restores it through Load, as the CLI does
+func saveAndLoad(t *testing.T, tok Interface) Interface {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vocab.json")
+	if err := tok.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if reflect.TypeOf(loaded) != reflect.TypeOf(tok) {
+		t.Fatalf("Load() returned %T, want %T", loaded, tok)
+	}
+	return loaded
+}
+
+func TestBPE_RoundTrip(t *testing.T) {
+	bpe := NewBPE(60)
+	bpe.Train(roundTripCorpus)
+	loaded := saveAndLoad(t, bpe)
+
+	for _, text := range []string{
+		roundTripCorpus,
+		"hear me speak",
+		"Citizen: proceed, All.",
+		"",
+	} {
+		ids := bpe.Encode(text)
+		if got := loaded.Encode(text); !reflect.DeepEqual(got, ids) {
+			t.Errorf("loaded Encode(%q) = %v, want %v", text, got, ids)
+		}
+		// Whitespace is normalized, the words come back unchanged
+		want := strings.Join(strings.Fields(text), " ")
+		if got := loaded.Decode(ids, DecodeOptions{}); got != want {
+			t.Errorf("Decode(Encode(%q)) = %q, want %q", text, got, want)
+		}
+	}
+
+	unk := bpe.SpecialTokens()["<unk>"]
+	if got := bpe.Encode("QXJ"); !reflect.DeepEqual(got, []int{unk, unk, unk, bpe.vocab.GetID("</w>")}) {
+		t.Errorf("Encode(%q) = %v, want unknown characters as <unk>", "QXJ", got)
+	}
+}
+
+func TestBPE_EncodeMatchesTraining(t *testing.T) {
+	bpe := NewBPE(40)
+	bpe.Train(roundTripCorpus)
+
+	// Every word of the corpus is merged the way training merged it
+	words := countWords(roundTripCorpus, 1)
+	trainer := newBPETrainer(words, 1)
+	for _, merge := range bpe.merges {
+		first, second, _ := strings.Cut(merge, " ")
+		trainer.merge(symbolPair{first, second})
+	}
+	for i, w := range words {
+		var want []int
+		for _, symbol := range trainer.words[i] {
+			want = append(want, bpe.vocab.GetID(symbol))
+		}
+		if got := bpe.Encode(w.word); !reflect.DeepEqual(got, want) {
+			t.Errorf("Encode(%q) = %v, want %v (%q)", w.word, got, want, trainer.words[i])
+		}
+	}
+}
+
+func TestBPE_SpecialTokens(t *testing.T) {
+	bpe := NewBPE(20)
+	bpe.Train("a b")
+
+	want := map[string]int{"<s>": 0, "</s>": 1, "<unk>": 2, "<pad>": 3}
+	if got := bpe.SpecialTokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SpecialTokens() = %v, want %v", got, want)
+	}
+	ids := []int{0, bpe.vocab.GetID("a</w>"), 3, 1}
+	if got := bpe.Decode(ids, DecodeOptions{}); got != "<s> a <pad> </s>" {
+		t.Errorf("Decode() = %q, want special tokens as words", got)
+	}
+	if got := bpe.Decode(ids, DecodeOptions{SkipSpecial: true}); got != "a" {
+		t.Errorf("Decode(SkipSpecial) = %q, want special tokens skipped", got)
+	}
+}
+
+func TestBPE_CustomSpecialsSurviveSaveLoad(t *testing.T) {
+	bpe := NewBPE(30)
+	bpe.SetSpecials(Specials{EOS: "<|end|>", UNK: "<?>", Additional: []string{"<sep>"}})
+	bpe.Train("one two three")
+
+	want := map[string]int{"<|end|>": 0, "<?>": 1, "<sep>": 2}
+	loaded := saveAndLoad(t, bpe)
+	if got := loaded.SpecialTokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SpecialTokens() = %v, want %v", got, want)
+	}
+	if id, ok := SpecialID(loaded, loaded.Specials().EOS); !ok || id != 0 {
+		t.Errorf("EOS ID = %d, %v, want 0", id, ok)
+	}
+	if _, ok := SpecialID(loaded, loaded.Specials().BOS); ok {
+		t.Errorf("unset BOS has an ID")
+	}
+	if got := loaded.Encode("Z"); got[0] != 1 {
+		t.Errorf("Encode(%q) = %v, want the configured unknown token first", "Z", got)
+	}
+}
+
+func TestLoad_OldVocabularyGetsDefaultSpecials(t *testing.T) {
+	bpe := NewBPE(0)
+	if err := bpe.Load("../../data/vocab/vocab.json"); err != nil {
+		t.Fatal(err)
+	}
+	// This vocabulary predates special tokens and has none of the defaults
+	if got := bpe.SpecialTokens(); len(got) != 0 {
+		t.Errorf("SpecialTokens() = %v, want none", got)
+	}
+}
+
+func TestEncodeSpecial(t *testing.T) {
+	bpe := NewBPE(40)
+	bpe.SetSpecials(Specials{BOS: "<s>", EOS: "</s>", Additional: []string{"<s>x"}})
+	bpe.Train("hello world")
+
+	text := "<s>hello</s> world<s>x"
+	bos, eos, x := bpe.SpecialTokens()["<s>"], bpe.SpecialTokens()["</s>"], bpe.SpecialTokens()["<s>x"]
+	want := append([]int{bos}, bpe.Encode("hello")...)
+	want = append(want, eos)
+	want = append(want, bpe.Encode(" world")...)
+	want = append(want, x)
+	if got := EncodeSpecial(bpe, text); !reflect.DeepEqual(got, want) {
+		t.Errorf("EncodeSpecial(%q) = %v, want %v", text, got, want)
+	}
+	// Without opting in, special tokens in the text are ordinary characters
+	if got := bpe.Encode(text); reflect.DeepEqual(got, want) {
+		t.Errorf("Encode(%q) recognized special tokens", text)
+	}
+}
+
+func TestByteLevelBPE_SaveLoad(t *testing.T) {
+	b := newTestByteLevelBPE(t)
+	loaded := saveAndLoad(t, b)
+
+	if loaded.VocabSize() != b.VocabSize() {
+		t.Errorf("VocabSize() = %d, want %d", loaded.VocabSize(), b.VocabSize())
+	}
+	for _, text := range []string{"Hello world", " Hellllo\n\tworld  ", "unicode: 世界 🙂"} {
+		ids := b.Encode(text)
+		if got := loaded.Encode(text); !reflect.DeepEqual(got, ids) {
+			t.Errorf("loaded Encode(%q) = %v, want %v", text, got, ids)
+		}
+		if got := loaded.Decode(ids, DecodeOptions{}); got != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, got)
+		}
+	}
+}
+
+func TestLoad_Directory(t *testing.T) {
+	b := newTestByteLevelBPE(t)
+	dir := t.TempDir()
+	if err := b.Save(filepath.Join(dir, "vocab.json")); err != nil {
+		t.Fatal(err)
+	}
+	// A directory is read as a GPT-2 vocabulary, which vocab.json alone is not
+	if _, err := Load(dir); err == nil {
+		t.Errorf("Load() of a directory without merges succeeded")
+	}
+}
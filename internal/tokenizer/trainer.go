@@ -0,0 +1,294 @@
+package tokenizer
+
+import (
+	"container/heap"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// bpeTrainer learns merges incrementally. Every distinct word is stored
+// once with its frequency, pair counts are updated only for the words that
+// contain the merged pair, and the next merge is taken from a priority queue
+// rather than by scanning every pair.
+type bpeTrainer struct {
+	words [][]string // symbols of each distinct word
+	freqs []int      // occurrences of each distinct word
+
+	counts map[symbolPair]int
+	where  map[symbolPair]map[int]struct{} // words that may contain the pair
+	queue  pairQueue
+}
+
+// newBPETrainer counts the pairs of symbols in words using up to workers
+// goroutines
+func newBPETrainer(words []wordCount, workers int) *bpeTrainer {
+	t := &bpeTrainer{
+		words:  make([][]string, len(words)),
+		freqs:  make([]int, len(words)),
+		counts: make(map[symbolPair]int),
+		where:  make(map[symbolPair]map[int]struct{}),
+	}
+	for i, w := range words {
+		t.words[i] = wordSymbols(w.word)
+		t.freqs[i] = w.count
+	}
+
+	// Each worker counts a contiguous shard of the words
+	type shard struct {
+		counts map[symbolPair]int
+		where  map[symbolPair][]int
+	}
+	shards := make([]shard, workers)
+	var wg sync.WaitGroup
+	for s := range shards {
+		lo, hi := s*len(words)/workers, (s+1)*len(words)/workers
+		wg.Add(1)
+		go func(sh *shard) {
+			defer wg.Done()
+			sh.counts = make(map[symbolPair]int)
+			sh.where = make(map[symbolPair][]int)
+			for i := lo; i < hi; i++ {
+				symbols := t.words[i]
+				for j := 0; j < len(symbols)-1; j++ {
+					pair := symbolPair{symbols[j], symbols[j+1]}
+					sh.counts[pair] += t.freqs[i]
+					if ws := sh.where[pair]; len(ws) == 0 || ws[len(ws)-1] != i {
+						sh.where[pair] = append(ws, i)
+					}
+				}
+			}
+		}(&shards[s])
+	}
+	wg.Wait()
+
+	for _, sh := range shards {
+		for pair, count := range sh.counts {
+			t.counts[pair] += count
+		}
+		for pair, ws := range sh.where {
+			set := t.where[pair]
+			if set == nil {
+				set = make(map[int]struct{}, len(ws))
+				t.where[pair] = set
+			}
+			for _, i := range ws {
+				set[i] = struct{}{}
+			}
+		}
+	}
+	for pair, count := range t.counts {
+		t.queue = append(t.queue, newPairEntry(pair, count))
+	}
+	heap.Init(&t.queue)
+	return t
+}
+
+// best returns the most frequent pair, ties going to the pair that sorts
+// first, or false when no pairs are left
+func (t *bpeTrainer) best() (symbolPair, int, bool) {
+	for t.queue.Len() > 0 {
+		e := heap.Pop(&t.queue).(pairEntry)
+		// Entries are not updated in place; skip those whose count changed
+		if t.counts[e.pair] == e.count {
+			return e.pair, e.count, true
+		}
+	}
+	return symbolPair{}, 0, false
+}
+
+// merge replaces pair in every word containing it and updates the counts of
+// the pairs around it
+func (t *bpeTrainer) merge(pair symbolPair) {
+	changed := make(map[symbolPair]struct{})
+	update := func(i, sign int) {
+		symbols := t.words[i]
+		for j := 0; j < len(symbols)-1; j++ {
+			p := symbolPair{symbols[j], symbols[j+1]}
+			t.counts[p] += sign * t.freqs[i]
+			changed[p] = struct{}{}
+			if sign > 0 {
+				set := t.where[p]
+				if set == nil {
+					set = make(map[int]struct{})
+					t.where[p] = set
+				}
+				set[i] = struct{}{}
+			}
+		}
+	}
+
+	for i := range t.where[pair] {
+		if !containsPair(t.words[i], pair) {
+			continue
+		}
+		update(i, -1)
+		t.words[i] = mergeSymbols(t.words[i], pair)
+		update(i, +1)
+	}
+	delete(t.where, pair)
+
+	for p := range changed {
+		if count := t.counts[p]; count > 0 {
+			heap.Push(&t.queue, newPairEntry(p, count))
+		} else {
+			delete(t.counts, p)
+		}
+	}
+}
+
+func containsPair(symbols []string, pair symbolPair) bool {
+	for j := 0; j < len(symbols)-1; j++ {
+		if symbols[j] == pair.first && symbols[j+1] == pair.second {
+			return true
+		}
+	}
+	return false
+}
+
+// pairEntry is a pair and its count when it was queued. key is the pair as
+// written in the merges, which orders pairs of equal count.
+type pairEntry struct {
+	pair  symbolPair
+	key   string
+	count int
+}
+
+func newPairEntry(pair symbolPair, count int) pairEntry {
+	return pairEntry{pair, pair.first + " " + pair.second, count}
+}
+
+// pairQueue is a max-heap of pairs by count
+type pairQueue []pairEntry
+
+func (q pairQueue) Len() int { return len(q) }
+func (q pairQueue) Less(i, j int) bool {
+	if q[i].count != q[j].count {
+		return q[i].count > q[j].count
+	}
+	return q[i].key < q[j].key
+}
+func (q pairQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *pairQueue) Push(x any)   { *q = append(*q, x.(pairEntry)) }
+func (q *pairQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// wordCount is a distinct word of the corpus and its number of occurrences
+type wordCount struct {
+	word  string
+	count int
+}
+
+// WordCounts counts the distinct words of a corpus that is fed to it in
+// chunks, so the corpus never has to be in memory at once
+type WordCounts struct {
+	workers int
+	index   map[string]int
+	words   []wordCount
+}
+
+// NewWordCounts returns an empty count that splits every chunk between up to
+// workers goroutines. Values below 1 use runtime.NumCPU().
+func NewWordCounts(workers int) *WordCounts {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &WordCounts{workers: workers, index: make(map[string]int)}
+}
+
+// Add counts the whitespace-separated words of text. Words must not be split
+// between chunks.
+func (c *WordCounts) Add(text string) {
+	chunks := splitOnSpace(text, c.workers)
+	type chunkCounts struct {
+		order  []string
+		counts map[string]int
+	}
+	results := make([]chunkCounts, len(chunks))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(chunk string, r *chunkCounts) {
+			defer wg.Done()
+			r.counts = make(map[string]int)
+			forEachField(chunk, func(word string) {
+				if r.counts[word] == 0 {
+					r.order = append(r.order, word)
+				}
+				r.counts[word]++
+			})
+		}(chunk, &results[i])
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		for _, word := range r.order {
+			if i, ok := c.index[word]; ok {
+				c.words[i].count += r.counts[word]
+				continue
+			}
+			// Copy the word so the chunk it came from can be freed
+			word = strings.Clone(word)
+			c.index[word] = len(c.words)
+			c.words = append(c.words, wordCount{word, r.counts[word]})
+		}
+	}
+}
+
+// Len returns the number of distinct words
+func (c *WordCounts) Len() int {
+	return len(c.words)
+}
+
+// countWords splits corpus on whitespace and counts every distinct word,
+// using up to workers goroutines. Words are returned in the order they first
+// appear.
+func countWords(corpus string, workers int) []wordCount {
+	c := NewWordCounts(workers)
+	c.Add(corpus)
+	return c.words
+}
+
+// splitOnSpace cuts s into about n chunks, only at ASCII whitespace so no
+// word is split
+func splitOnSpace(s string, n int) []string {
+	var chunks []string
+	for n > 1 && len(s) > 0 {
+		end := len(s) / n
+		for end < len(s) && !isASCIISpace(s[end]) {
+			end++
+		}
+		chunks = append(chunks, s[:end])
+		s = s[end:]
+		n--
+	}
+	return append(chunks, s)
+}
+
+// forEachField calls fn for every whitespace-separated field of s, like
+// strings.Fields without building the slice
+func forEachField(s string, fn func(string)) {
+	start := -1
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				fn(s[start:i])
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fn(s[start:])
+	}
+}
+
+func isASCIISpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
@@ -0,0 +1,122 @@
+package tokenizer
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// trainSequential is the former trainer: every iteration counts the pairs
+// of every word occurrence and rewrites every word. It is the reference for
+// the incremental trainer.
+func trainSequential(corpus string, maxVocab int) ([]string, map[string]int) {
+	vocab := NewVocab()
+	for _, token := range DefaultSpecials().Tokens() {
+		vocab.Add(token)
+	}
+	var words [][]string
+	for _, word := range strings.Fields(corpus) {
+		symbols := wordSymbols(word)
+		for _, symbol := range symbols {
+			vocab.Add(symbol)
+		}
+		words = append(words, symbols)
+	}
+
+	var merges []string
+	for vocab.Len() < maxVocab {
+		pairs := make(map[string]int)
+		for _, symbols := range words {
+			for j := 0; j < len(symbols)-1; j++ {
+				pairs[symbols[j]+" "+symbols[j+1]]++
+			}
+		}
+		if len(pairs) == 0 {
+			break
+		}
+		keys := make([]string, 0, len(pairs))
+		for k := range pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if pairs[keys[i]] != pairs[keys[j]] {
+				return pairs[keys[i]] > pairs[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+
+		first, second, _ := strings.Cut(keys[0], " ")
+		for i := range words {
+			words[i] = mergeSymbols(words[i], symbolPair{first, second})
+		}
+		merges = append(merges, keys[0])
+		vocab.Add(first + second)
+	}
+	return merges, vocab.word2id
+}
+
+func TestBPE_TrainMatchesSequentialTrainer(t *testing.T) {
+	_, corpus := loadShakespeare(t, 20000)
+	wantMerges, wantVocab := trainSequential(corpus, 400)
+
+	for _, workers := range []int{1, 3} {
+		bpe := NewBPE(400)
+		bpe.SetWorkers(workers)
+		bpe.Train(corpus)
+
+		if !reflect.DeepEqual(bpe.merges, wantMerges) {
+			t.Errorf("workers=%d: merges differ from the sequential trainer", workers)
+		}
+		if !reflect.DeepEqual(bpe.vocab.word2id, wantVocab) {
+			t.Errorf("workers=%d: vocabulary differs from the sequential trainer", workers)
+		}
+	}
+}
+
+func TestCountWords(t *testing.T) {
+	corpus := "the cat\tsat on\nthe  mat, the end cat"
+	want := []wordCount{{"the", 3}, {"cat", 2}, {"sat", 1}, {"on", 1}, {"mat,", 1}, {"end", 1}}
+	for workers := 1; workers <= 8; workers++ {
+		if got := countWords(corpus, workers); !reflect.DeepEqual(got, want) {
+			t.Errorf("countWords(workers=%d) = %v, want %v", workers, got, want)
+		}
+	}
+}
+
+func TestBPETrainer_OverlappingPairs(t *testing.T) {
+	// "a a a a" merges into "aa aa": the pair counts must match a recount
+	trainer := newBPETrainer([]wordCount{{"aaaa", 2}, {"aaa", 1}}, 1)
+	trainer.merge(symbolPair{"a", "a"})
+
+	want := make(map[symbolPair]int)
+	for i, symbols := range trainer.words {
+		for j := 0; j < len(symbols)-1; j++ {
+			want[symbolPair{symbols[j], symbols[j+1]}] += trainer.freqs[i]
+		}
+	}
+	if !reflect.DeepEqual(trainer.counts, want) {
+		t.Errorf("counts = %v, want %v", trainer.counts, want)
+	}
+}
+
+func BenchmarkBPE_Train(b *testing.B) {
+	_, corpus := loadShakespeare(b, 1<<20)
+	b.SetBytes(int64(len(corpus)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpe := NewBPE(2000)
+		bpe.Train(corpus)
+	}
+}
+
+func TestWordCounts_Chunked(t *testing.T) {
+	lines := []string{"the cat sat\n", "on the mat\n", "the end\n"}
+	counts := NewWordCounts(2)
+	for _, line := range lines {
+		counts.Add(line)
+	}
+	if want := countWords(strings.Join(lines, ""), 1); !reflect.DeepEqual(counts.words, want) {
+		t.Errorf("chunked counts = %v, want %v", counts.words, want)
+	}
+}
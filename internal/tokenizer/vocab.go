@@ -27,6 +27,16 @@ func (v *Vocab) Add(word string) {
 	v.counts[word]++
 }
 
+// addCount adds word if it is new and counts n occurrences of it
+func (v *Vocab) addCount(word string, n int) {
+	if _, exists := v.word2id[word]; !exists {
+		id := len(v.word2id)
+		v.word2id[word] = id
+		v.id2word[id] = word
+	}
+	v.counts[word] += n
+}
+
 func (v *Vocab) Len() int {
 	return len(v.word2id)
 }